@@ -1,5 +1,10 @@
 package main
 
+import (
+	"math"
+	"strconv"
+)
+
 type NetworkInfo struct {
 	NetworkName   string `json:"network_name"`
 	NetworkPrefix string `json:"network_prefix"`
@@ -54,19 +59,44 @@ type BlockchainState struct {
 	Success bool
 }
 
-// Chia node types from server/outbound_message.py
+type MempoolItem struct {
+	Cost                 int64
+	Fee                  int64
+	HeightAddedToMempool int64 `json:"height_added_to_mempool"`
+}
+
+// NodeType is the type of a peer, from server/outbound_message.py
+type NodeType int
+
 const (
-	NodeTypeNone = iota
+	NodeTypeNone NodeType = iota
 	NodeTypeFullNode
 	NodeTypeHarvester
 	NodeTypeFarmer
 	NodeTypeTimelord
 	NodeTypeIntroducer
 	NodeTypeWallet
-	NumNodeTypes = 6
+
+	// NumNodeTypes is the number of known node types, not counting none.
+	NumNodeTypes = int(NodeTypeWallet)
 )
 
-type NodeType int
+var nodeTypeNames = map[NodeType]string{
+	NodeTypeFullNode:   "full_node",
+	NodeTypeHarvester:  "harvester",
+	NodeTypeFarmer:     "farmer",
+	NodeTypeTimelord:   "timelord",
+	NodeTypeIntroducer: "introducer",
+	NodeTypeWallet:     "wallet",
+}
+
+// String returns the name of the node type, or its number if unknown.
+func (t NodeType) String() string {
+	if name, ok := nodeTypeNames[t]; ok {
+		return name
+	}
+	return strconv.Itoa(int(t))
+}
 
 type Connections struct {
 	Connections []struct {
@@ -88,17 +118,65 @@ type Connections struct {
 }
 
 type Wallet struct {
-	ID        int
-	Name      string
-	Type      int
-	Data      string
-	StringID  string
-	PublicKey string
+	ID          int
+	Name        string
+	Type        int
+	Data        string
+	StringID    string
+	PublicKey   string
+	TypeName    string
+	AssetName   string
+	AssetSymbol string
+}
+
+// Chia wallet types from wallet/util/wallet_types.py
+const (
+	WalletTypeStandard        = 0
+	WalletTypeAtomicSwap      = 2
+	WalletTypeAuthorizedPayee = 3
+	WalletTypeMultiSig        = 4
+	WalletTypeCustody         = 5
+	WalletTypeCAT             = 6
+	WalletTypeRecoverable     = 7
+	WalletTypeDID             = 8
+	WalletTypePool            = 9
+	WalletTypeNFT             = 10
+	WalletTypeDataLayer       = 11
+	WalletTypeDataLayerOffer  = 12
+	WalletTypeVC              = 13
+	WalletTypeCRCAT           = 57
+)
+
+var walletTypeNames = map[int]string{
+	WalletTypeStandard:        "standard",
+	WalletTypeAtomicSwap:      "atomic_swap",
+	WalletTypeAuthorizedPayee: "authorized_payee",
+	WalletTypeMultiSig:        "multi_sig",
+	WalletTypeCustody:         "custody",
+	WalletTypeCAT:             "cat",
+	WalletTypeRecoverable:     "recoverable",
+	WalletTypeDID:             "did",
+	WalletTypePool:            "pool",
+	WalletTypeNFT:             "nft",
+	WalletTypeDataLayer:       "data_layer",
+	WalletTypeDataLayerOffer:  "data_layer_offer",
+	WalletTypeVC:              "vc",
+	WalletTypeCRCAT:           "crcat",
+}
+
+// walletTypeName returns the name of wallet type t, or the number itself for
+// types not known here.
+func walletTypeName(t int) string {
+	if n, ok := walletTypeNames[t]; ok {
+		return n
+	}
+	return strconv.Itoa(t)
 }
 
 type Wallets struct {
 	Wallets []Wallet
 	Success bool
+	Error   string
 }
 
 type WalletBalance struct {
@@ -113,6 +191,27 @@ type WalletBalance struct {
 	Success bool
 }
 
+// CATList is the wallet's list of well-known CATs.
+type CATList struct {
+	CATList []struct {
+		AssetID string `json:"asset_id"`
+		Name    string
+		Symbol  string
+	} `json:"cat_list"`
+	Success bool
+}
+
+type CATAssetID struct {
+	AssetID string `json:"asset_id"`
+	Success bool
+}
+
+// CATAssetName is the name of a CAT, null if the wallet doesn't know it.
+type CATAssetName struct {
+	Name    string
+	Success bool
+}
+
 type WalletSyncStatus struct {
 	GenesisInitialized bool `json:"genesis_initialized"`
 	Synced             bool
@@ -139,6 +238,74 @@ type FarmedAmount struct {
 	Success          bool
 }
 
+type Transaction struct {
+	Amount            int64
+	Confirmed         bool
+	ConfirmedAtHeight int64 `json:"confirmed_at_height"`
+	CreatedAtTime     int64 `json:"created_at_time"`
+	FeeAmount         int64 `json:"fee_amount"`
+	Name              string
+	ToAddress         string `json:"to_address"`
+	Type              int
+	WalletID          int `json:"wallet_id"`
+}
+
+// Chia transaction types from wallet/util/transaction_type.py
+const (
+	TransactionTypeIncoming      = 0
+	TransactionTypeOutgoing      = 1
+	TransactionTypeCoinbase      = 2
+	TransactionTypeFeeReward     = 3
+	TransactionTypeIncomingTrade = 4
+	TransactionTypeOutgoingTrade = 5
+)
+
+type Transactions struct {
+	Transactions []Transaction
+	WalletID     int `json:"wallet_id"`
+	Success      bool
+}
+
+// Pool singleton states from pools/pool_wallet_info.py
+const (
+	PoolStateSelfPooling   = 1
+	PoolStateLeavingPool   = 2
+	PoolStateFarmingToPool = 3
+)
+
+// PoolWalletStatus is the state of a pool (plot NFT) wallet. Target is null
+// unless the wallet is switching to another state.
+type PoolWalletStatus struct {
+	State struct {
+		Current struct {
+			State   int
+			PoolURL string `json:"pool_url"`
+		}
+		Target *struct {
+			State   int
+			PoolURL string `json:"pool_url"`
+		}
+	}
+	Success bool
+}
+
+// Routes are the RPC routes served by a service.
+type Routes struct {
+	Routes  []string
+	Success bool
+}
+
+// Notifications are on-chain messages to the wallet, e.g. offers.
+type Notifications struct {
+	Notifications []struct {
+		ID      string
+		Message string
+		Amount  int64
+		Height  int64
+	}
+	Success bool
+}
+
 type PoolState struct {
 	PoolState []struct {
 		CurrentDificulty      int64        `json:"current_difficulty"`
@@ -154,15 +321,16 @@ type PoolState struct {
 }
 
 type PlotData struct {
-	FileSize      int64   `json:"file_size"`
-	Filename      string  `json:"filename"`
-	PlotSeed      string  `json:"plot-seed"`
-	PlotID        string  `json:"plot_id"`
-	PublicKey     string  `json:"plot_public_key"`
-	PoolContract  string  `json:"pool_contract_puzzle_hash"`
-	PoolPublicKey string  `json:"pool_public_key"`
-	Size          int64   `json:"size"`
-	TimeModified  float64 `json:"time_modified"`
+	CompressionLevel int     `json:"compression_level"`
+	FileSize         int64   `json:"file_size"`
+	Filename         string  `json:"filename"`
+	PlotSeed         string  `json:"plot-seed"`
+	PlotID           string  `json:"plot_id"`
+	PublicKey        string  `json:"plot_public_key"`
+	PoolContract     string  `json:"pool_contract_puzzle_hash"`
+	PoolPublicKey    string  `json:"pool_public_key"`
+	Size             int64   `json:"size"`
+	TimeModified     float64 `json:"time_modified"`
 }
 
 type PlotFiles struct {
@@ -171,3 +339,161 @@ type PlotFiles struct {
 	Plots        []PlotData `json:"plots"`
 	Success      bool
 }
+
+type PlotDirectories struct {
+	Directories []string
+	Success     bool
+}
+
+type Harvester struct {
+	Connection struct {
+		NodeID string `json:"node_id"`
+		Host   string
+		Port   int
+	}
+	FailedToOpen []string   `json:"failed_to_open_filenames"`
+	NoKey        []string   `json:"no_key_filenames"`
+	Plots        []PlotData `json:"plots"`
+	// Time of the last completed plot sync from the harvester, null until
+	// the first one completes
+	LastSyncTime *float64 `json:"last_sync_time"`
+	// Progress of a plot refresh, null while the harvester is not refreshing
+	Syncing *struct {
+		Initial            bool
+		PlotFilesProcessed int `json:"plot_files_processed"`
+		PlotFilesTotal     int `json:"plot_files_total"`
+	}
+}
+
+type Harvesters struct {
+	Harvesters []Harvester
+	Success    bool
+}
+
+// Chia consensus constants from consensus/default_constants.py that are the
+// same on every network
+const (
+	BlocksPerDay = 4608
+	BlockTime    = 86400.0 / BlocksPerDay
+	MojoPerXCH   = 1e12
+
+	NumberZeroBitsPlotFilter = 9
+)
+
+// Consensus holds the Chia consensus constants that differ by network.
+type Consensus struct {
+	// Blocks per halving period of the block reward. block_rewards.py uses
+	// the same schedule on every network.
+	BlocksPerYear uint32
+	EpochBlocks   uint32
+
+	HardForkHeight      uint32
+	PlotFilter128Height uint32
+	PlotFilter64Height  uint32
+	PlotFilter32Height  uint32
+}
+
+// networks are the consensus constants of each network -network accepts, from
+// consensus/default_constants.py and the network_overrides of
+// util/initial-config.yaml.
+var networks = map[string]Consensus{
+	"mainnet": {
+		BlocksPerYear:       1681920,
+		EpochBlocks:         4608,
+		HardForkHeight:      5496000,
+		PlotFilter128Height: 10542000,
+		PlotFilter64Height:  15592000,
+		PlotFilter32Height:  20643000,
+	},
+	"testnet11": {
+		BlocksPerYear:       1681920,
+		EpochBlocks:         768,
+		HardForkHeight:      0,
+		PlotFilter128Height: 6029568,
+		PlotFilter64Height:  11075328,
+		PlotFilter32Height:  16121088,
+	},
+}
+
+// blockRewardAtHeight returns the total block reward (pool plus farmer) in XCH
+// for a block at height, following the halving schedule in
+// consensus/block_rewards.py. Height 0 carries the prefarm.
+func (c Consensus) blockRewardAtHeight(height uint32) float64 {
+	switch {
+	case height == 0:
+		return 21000000
+	case height < 3*c.BlocksPerYear:
+		return 2
+	case height < 6*c.BlocksPerYear:
+		return 1
+	case height < 9*c.BlocksPerYear:
+		return 0.5
+	case height < 12*c.BlocksPerYear:
+		return 0.25
+	default:
+		return 0.125
+	}
+}
+
+// plotFilterAtHeight returns the number of zero bits required to pass the plot
+// filter at height, following calculate_prefix_bits in
+// consensus/pot_iterations.py. One in 2^bits plots passes the filter.
+func (c Consensus) plotFilterAtHeight(height uint32) int {
+	switch {
+	case height >= c.PlotFilter32Height:
+		return NumberZeroBitsPlotFilter - 4
+	case height >= c.PlotFilter64Height:
+		return NumberZeroBitsPlotFilter - 3
+	case height >= c.PlotFilter128Height:
+		return NumberZeroBitsPlotFilter - 2
+	case height >= c.HardForkHeight:
+		return NumberZeroBitsPlotFilter - 1
+	default:
+		return NumberZeroBitsPlotFilter
+	}
+}
+
+// secondsToNextEpoch estimates the time until the next epoch boundary after
+// height, where difficulty is adjusted, at the target block time. At a boundary
+// the next one is a whole epoch away.
+func (c Consensus) secondsToNextEpoch(height uint32) float64 {
+	return float64(c.EpochBlocks-height%c.EpochBlocks) * BlockTime
+}
+
+// winProbability returns the probability that a farm of effective size space
+// wins a given block, out of the estimated netspace. The plot filter does not
+// appear: it drops the same share of the farm's plots as of the network's, and
+// the netspace estimate already accounts for it.
+func winProbability(space, netspace float64) float64 {
+	if netspace <= 0 {
+		return 0
+	}
+	return math.Min(space/netspace, 1)
+}
+
+// compressionSizes are the sizes of k32 plots in GiB by compression level, as
+// documented for the bladebit plotter. A compressed plot farms like an
+// uncompressed one, so its effective size is scaled up by the ratio to C0.
+var compressionSizes = []float64{101.4, 87.5, 86.0, 84.4, 82.8, 81.2, 79.6, 78.0, 76.4, 74.8}
+
+// effectivePlotSize returns the size of the plot scaled to the size it would
+// have uncompressed.
+func effectivePlotSize(p PlotData) float64 {
+	if p.CompressionLevel <= 0 || p.CompressionLevel >= len(compressionSizes) {
+		return float64(p.FileSize)
+	}
+	return float64(p.FileSize) * compressionSizes[0] / compressionSizes[p.CompressionLevel]
+}
+
+// farmingType returns how the plot is farmed: "pooled" for plot NFT plots,
+// which carry a pool contract puzzle hash, "og" for solo and original pool
+// plots, which carry a pool public key, or "unknown" if neither is set.
+func farmingType(p PlotData) string {
+	switch {
+	case p.PoolContract != "":
+		return "pooled"
+	case p.PoolPublicKey != "":
+		return "og"
+	}
+	return "unknown"
+}
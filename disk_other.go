@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// diskFree is not supported on this platform.
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("disk free space is not supported on " + runtime.GOOS)
+}
@@ -2,4 +2,9 @@ module github.com/artanicus/chia_exporter
 
 go 1.14
 
-require github.com/prometheus/client_golang v1.10.0
+require (
+	github.com/prometheus/client_golang v1.10.0
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.18.0
+	golang.org/x/net v0.0.0-20200625001655-4c5254603344
+)
@@ -15,34 +15,112 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"golang.org/x/net/http/httpproxy"
+	netproxy "golang.org/x/net/proxy"
 )
 
 var (
-	addr      = flag.String("listen", ":9133", "The address to listen on for HTTP requests.")
+	addr      = flag.String("listen", ":9133", "The address to listen on for HTTP requests, or a comma-separated list of addresses.")
+	network   = flag.String("network", "mainnet", "The Chia network, mainnet or testnet11. Selects the network's directory in the default -cert and -key and its consensus constants.")
 	cert      = flag.String("cert", "$HOME/.chia/mainnet/config/ssl/full_node/private_full_node.crt", "The full node SSL certificate.")
 	key       = flag.String("key", "$HOME/.chia/mainnet/config/ssl/full_node/private_full_node.key", "The full node SSL key.")
 	full_node = flag.String("full_node", "https://localhost:8555", "The base URL for the full node RPC endpoint.")
 	wallet    = flag.String("wallet", "https://localhost:9256", "The base URL for the wallet RPC endpoint.")
 	farmer    = flag.String("farmer", "https://localhost:8559", "The base URL for the farmer RPC endpoint.")
 	harvester = flag.String("harvester", "https://localhost:8560", "The base URL for the harvester RPC endpoint.")
+	legacyURL = flag.String("url", "", "Deprecated alias for -full_node.")
 	timeout   = flag.String("timeout", "5s", "HTTP client timeout per request, as duration string.")
+	userAgent = flag.String("user_agent", "", "The User-Agent sent to the RPC endpoints. Defaults to chia_exporter/<version>.")
+	insecure  = flag.Bool("insecure", true, "Skip verification of the endpoints' SSL certificates. Set -insecure=false to verify them against -ca.")
+	ca        = flag.String("ca", "", "The CA certificate to verify endpoints with when -insecure=false, e.g. $HOME/.chia/mainnet/config/ssl/ca/private_ca.crt. Defaults to the system roots.")
+
+	maxResponseBytes = flag.Int64("max_response_bytes", 256<<20, "Maximum size of an RPC response, larger responses are rejected.")
+	maxIdleConns     = flag.Int("max_idle_conns", 100, "Maximum number of idle connections kept open to the RPC endpoints.")
+	idleConnTimeout  = flag.String("idle_conn_timeout", "90s", "How long idle connections to the RPC endpoints are kept open, as duration string.")
+	minTLSVersion    = flag.String("min_tls_version", "", "Minimum TLS version for connections to the RPC endpoints, 1.0, 1.1, 1.2 or 1.3. Defaults to that of Go. Does not affect the exporter's own listener.")
+
+	minScrapeInterval = flag.String("min_scrape_interval", "0s", "Serve the metrics of the previous scrape to scrapes arriving sooner than this after it, as duration string. 0 queries the endpoints on every scrape.")
+	withTimestamp     = flag.Bool("with_timestamp", false, "Stamp metrics with the time they were collected, for pushing them elsewhere. Scraped metrics normally should not carry timestamps.")
+	scrapeTimeout     = flag.String("scrape_timeout", "0s", "Maximum time a scrape waits for metrics, as duration string. Queries still running then are cancelled and the metrics not collected are omitted. 0 waits for all of them.")
+
+	proxy          = flag.String("proxy", "", "Proxy URL for all RPC endpoints, instead of the HTTPS_PROXY environment variable. NO_PROXY is still honored.")
+	full_nodeProxy = flag.String("full_node_proxy", "", "Proxy URL for the full node RPC endpoint, overriding -proxy.")
+	walletProxy    = flag.String("wallet_proxy", "", "Proxy URL for the wallet RPC endpoint, overriding -proxy.")
+	farmerProxy    = flag.String("farmer_proxy", "", "Proxy URL for the farmer RPC endpoint, overriding -proxy.")
+	harvesterProxy = flag.String("harvester_proxy", "", "Proxy URL for the harvester RPC endpoint, overriding -proxy.")
+
+	socks5          = flag.String("socks5", "", "SOCKS5 proxy as host:port to dial all RPC endpoints through, e.g. an SSH tunnel with ssh -D.")
+	full_nodeSocks5 = flag.String("full_node_socks5", "", "SOCKS5 proxy as host:port for the full node RPC endpoint, overriding -socks5. \"direct\" dials it directly.")
+	walletSocks5    = flag.String("wallet_socks5", "", "SOCKS5 proxy as host:port for the wallet RPC endpoint, overriding -socks5. \"direct\" dials it directly.")
+	farmerSocks5    = flag.String("farmer_socks5", "", "SOCKS5 proxy as host:port for the farmer RPC endpoint, overriding -socks5. \"direct\" dials it directly.")
+	harvesterSocks5 = flag.String("harvester_socks5", "", "SOCKS5 proxy as host:port for the harvester RPC endpoint, overriding -socks5. \"direct\" dials it directly.")
+
+	full_nodeCert = flag.String("full_node_cert", "", "The SSL certificate for the full node RPC endpoint, instead of -cert.")
+	full_nodeKey  = flag.String("full_node_key", "", "The SSL key for the full node RPC endpoint, instead of -key.")
+	walletCert    = flag.String("wallet_cert", "", "The SSL certificate for the wallet RPC endpoint, instead of -cert.")
+	walletKey     = flag.String("wallet_key", "", "The SSL key for the wallet RPC endpoint, instead of -key.")
+	farmerCert    = flag.String("farmer_cert", "", "The SSL certificate for the farmer RPC endpoint, instead of -cert.")
+	farmerKey     = flag.String("farmer_key", "", "The SSL key for the farmer RPC endpoint, instead of -key.")
+	harvesterCert = flag.String("harvester_cert", "", "The SSL certificate for the harvester RPC endpoint, instead of -cert.")
+	harvesterKey  = flag.String("harvester_key", "", "The SSL key for the harvester RPC endpoint, instead of -key.")
+	hostCerts     = flag.String("host_certs", "", "Comma-separated list of host=cert:key, the SSL certificate and key for endpoints on each host, instead of -cert and -key. The per endpoint flags take precedence.")
+
+	full_nodeServerName = flag.String("full_node_servername", "", "The name to verify the full node's SSL certificate against, instead of its host. Requires -insecure=false and -ca.")
+	walletServerName    = flag.String("wallet_servername", "", "The name to verify the wallet's SSL certificate against, instead of its host. Requires -insecure=false and -ca.")
+	farmerServerName    = flag.String("farmer_servername", "", "The name to verify the farmer's SSL certificate against, instead of its host. Requires -insecure=false and -ca.")
+	harvesterServerName = flag.String("harvester_servername", "", "The name to verify the harvester's SSL certificate against, instead of its host. Requires -insecure=false and -ca.")
+
+	mempoolDetails  = flag.Bool("mempool_details", false, "Collect details of individual mempool items, which can be expensive on a large mempool.")
+	plotsSource     = flag.String("plots_source", "both", "Where to collect plot metrics from: \"harvester\" (get_plots on -harvester), \"farmer\" (get_harvesters on -farmer, covering all its harvesters) or \"both\". On a combined farmer and harvester both report the same plots.")
+	localDisk       = flag.Bool("local_disk", false, "Report free space of the harvester's plot directories. Only works when running on the harvester host.")
+	mempoolMaxItems = flag.Int("mempool_max_items", 10000, "Maximum number of mempool items to parse with -mempool_details.")
+
+	maxNotifications = flag.Int("max_notifications", 1000, "Maximum number of wallet notifications to fetch and count.")
+	usedAddresses    = flag.Bool("used_addresses", false, "Expose chia_wallet_used_addresses, the approximate number of distinct addresses the standard wallets received coins on. Fetches up to -max_transactions transactions per wallet every scrape.")
+	maxTransactions  = flag.Int("max_transactions", 10000, "Maximum number of transactions per wallet to fetch with -used_addresses, and of pending transactions to count per wallet.")
+
+	harvesterStaleAfter = flag.String("harvester_stale_after", "5m", "How long since its last plot sync a harvester listed by the farmer is considered disconnected, as duration string.")
+	discoverRoutes      = flag.Bool("discover_routes", false, "Ask each endpoint for its RPC routes at startup with get_routes, and skip optional collectors whose routes are missing.")
+	farmHealth          = flag.Bool("farm_health", false, "Expose chia_farm_health, the share of healthy signals of the farm from 0 to 1.")
+
+	verbose      = flag.Bool("verbose", false, "Log additional detail useful for debugging.")
+	debug        = flag.Bool("debug", false, "Serve raw RPC responses on /debug/<service>/<rpc>. Do not expose publicly.")
+	routePrefix  = flag.String("route_prefix", "", "Path prefix to serve all routes under, e.g. /chia when behind a reverse proxy.")
+	jsonEndpoint = flag.Bool("json_endpoint", false, "Also serve metrics in JSON format on /metrics.json.")
+	check        = flag.Bool("check", false, "Check the configuration and that each enabled endpoint answers get_version, print a report and exit.")
+
+	metricsFile         = flag.String("metrics_file", "", "Also write the metrics in text format to this file every -metrics_file_interval, e.g. for a log shipper on an air-gapped farm.")
+	metricsFileInterval = flag.String("metrics_file_interval", "60s", "How often to write -metrics_file, as duration string.")
 )
 
 var (
@@ -52,159 +130,1932 @@ var (
 func main() {
 	log.Printf("chia_exporter version %s", Version)
 
-        // Alias legacy flags
-        flag.StringVar(full_node, "url", *full_node, "Legacy compatibility alias for -full_node")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Every flag can also be set with a %s<FLAG> environment variable, e.g. %sFULL_NODE. Flags given on the command line take precedence.\n", envPrefix, envPrefix)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	if err := flagsFromEnv(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	applyLegacyURL(flag.CommandLine)
+	applyNetwork(flag.CommandLine)
+	switch *plotsSource {
+	case "both", "farmer", "harvester":
+	default:
+		log.Fatalf("Invalid -plots_source %q, must be both, farmer or harvester", *plotsSource)
+	}
+	staleAfter, err := time.ParseDuration(*harvesterStaleAfter)
+	if err != nil {
+		log.Fatalf("Invalid -harvester_stale_after: %v", err)
+	}
+	minInterval, err := time.ParseDuration(*minScrapeInterval)
+	if err != nil {
+		log.Fatalf("Invalid -min_scrape_interval: %v", err)
+	}
+	budget, err := time.ParseDuration(*scrapeTimeout)
+	if err != nil {
+		log.Fatalf("Invalid -scrape_timeout: %v", err)
+	}
+
+	// Validate RPC endpoints and disable invalid ones
+	endpoints := []struct {
+		name       string
+		url        *string
+		cert, key  *string
+		serverName *string
+	}{
+		{"full_node", full_node, full_nodeCert, full_nodeKey, full_nodeServerName},
+		{"wallet", wallet, walletCert, walletKey, walletServerName},
+		{"farmer", farmer, farmerCert, farmerKey, farmerServerName},
+		{"harvester", harvester, harvesterCert, harvesterKey, harvesterServerName},
+	}
+	var invalidEndpoints int
+	for _, e := range endpoints {
+		u, err := url.ParseRequestURI(*e.url)
+		if err != nil {
+			log.Printf("Disabling invalid endpoint: %+v", err)
+			*e.url = "disabled"
+			invalidEndpoints++
+			continue
+		}
+		if *e.url, err = endpointBase(e.name, u); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *insecure {
+		log.Print("Not verifying endpoint SSL certificates, use -insecure=false with -ca to verify them")
+	}
+	proxyFunc, err := newProxyFunc(map[string]string{
+		*full_node: *full_nodeProxy,
+		*wallet:    *walletProxy,
+		*farmer:    *farmerProxy,
+		*harvester: *harvesterProxy,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := setupSocks(map[string]string{
+		*full_node: *full_nodeSocks5,
+		*wallet:    *walletSocks5,
+		*farmer:    *farmerSocks5,
+		*harvester: *harvesterSocks5,
+	}); err != nil {
+		log.Fatal(err)
+	}
+	byHost, err := parseHostCerts(*hostCerts)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	client, err := newClient(os.ExpandEnv(*cert), os.ExpandEnv(*key))
+	client, leaf, err := newClient(os.ExpandEnv(*cert), os.ExpandEnv(*key), "", proxyFunc)
 	if err != nil {
 		log.Fatal(err)
 	}
+	certExpiry := map[string]time.Time{os.ExpandEnv(*cert): leaf.NotAfter}
 
-        // Validate RPC endpoints and disable invalid ones
-        endpoints := []*string{full_node, wallet, farmer, harvester}
+	// Endpoints with their own SSL material or server name get their own
+	// client, shared by endpoints with the same settings.
+	type clientKey struct{ cert, key, serverName string }
+	shared := map[clientKey]*http.Client{
+		{os.ExpandEnv(*cert), os.ExpandEnv(*key), ""}: client,
+	}
+	clients := make(map[string]*http.Client)
 	for _, e := range endpoints {
-                _, err = url.ParseRequestURI(*e)
-                if err != nil {
-                        log.Printf("Disabling invalid endpoint: %+v", err)
-                        *e = "disabled"
-                } else if !strings.HasPrefix(*e, "https://") {
-                        log.Fatal("Endpoint URL does not start with https://, endpoint SSL is mandatory: ", *e)
-                }
-        }
-
-	cc := ChiaCollector{
+		if *e.url == "disabled" {
+			continue
+		}
+		if *e.serverName != "" && (*insecure || *ca == "") {
+			log.Fatalf("-%s_servername is only used when verifying certificates, set -insecure=false and -ca", e.name)
+		}
+		k := clientKey{os.ExpandEnv(*cert), os.ExpandEnv(*key), *e.serverName}
+		if u, err := url.Parse(*e.url); err == nil {
+			if pair, ok := byHost[u.Hostname()]; ok {
+				k.cert, k.key = pair[0], pair[1]
+			}
+		}
+		if *e.cert != "" || *e.key != "" {
+			if *e.cert == "" || *e.key == "" {
+				log.Fatalf("Both -%s_cert and -%s_key must be given", e.name, e.name)
+			}
+			k.cert, k.key = os.ExpandEnv(*e.cert), os.ExpandEnv(*e.key)
+		}
+		hc, ok := shared[k]
+		if !ok {
+			var leaf *x509.Certificate
+			hc, leaf, err = newClient(k.cert, k.key, k.serverName, proxyFunc)
+			if err != nil {
+				log.Fatal(err)
+			}
+			shared[k] = hc
+			certExpiry[k.cert] = leaf.NotAfter
+		}
+		if hc != client {
+			clients[*e.url] = hc
+		}
+	}
+
+	cc := &ChiaCollector{
 		client:       client,
+		clients:      clients,
 		full_nodeURL: *full_node,
 		walletURL:    *wallet,
 		farmerURL:    *farmer,
 		harvesterURL: *harvester,
+		certExpiry:   certExpiry,
+
+		harvesterStaleAfter: staleAfter,
+		consensus:           networks[*network],
+		minScrapeInterval:   minInterval,
+		scrapeTimeout:       budget,
+		rounds:              make(chan struct{}, 1),
+
+		poolDifficulty:        make(map[string]int64),
+		poolDifficultyChanges: make(map[string]float64),
+		poolPoints:            make(map[string]poolPoints),
+		poolPointsEarned:      make(map[string]float64),
+		endpointOK:            make(map[string]bool),
+		consecutiveFailures:   make(map[string]int),
+		responseBytes:         make(map[rpcKey]int64),
+		missingRoutes:         make(map[rpcKey]bool),
+		stageErrors:           map[string]float64{"config": float64(invalidEndpoints)},
+	}
+	if *discoverRoutes {
+		cc.discoverRoutes()
+	}
+	if *check {
+		if !cc.check(os.Stdout) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		cc,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	prefix := strings.TrimSuffix(*routePrefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	http.HandleFunc(prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "chia_exporter version %s\n", Version)
+		fmt.Fprintf(w, "metrics are published on %s/metrics\n\n", prefix)
+		fmt.Fprintf(w, "This program is free software released under the GNU AGPL.\n")
+		fmt.Fprintf(w, "The source code is availabe at https://github.com/artanicus/chia_exporter\n\n")
+		cc.writeStatus(w)
+	})
+	http.Handle(prefix+"/metrics", metricsHandler(reg))
+	http.HandleFunc(prefix+"/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ok\n")
+	})
+	http.HandleFunc(prefix+"/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !cc.Ready() {
+			http.Error(w, "no endpoint scraped successfully yet", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "ready\n")
+	})
+	if *debug {
+		http.Handle(prefix+"/debug/", http.StripPrefix(prefix, http.HandlerFunc(cc.serveDebug)))
+	}
+	// The other gathers don't count as scrapes for
+	// chia_endpoint_consecutive_failures, so that they don't skew it.
+	uncounted := prometheus.NewRegistry()
+	uncounted.MustRegister(
+		uncountedCollector{cc},
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	if *jsonEndpoint {
+		http.HandleFunc(prefix+"/metrics.json", func(w http.ResponseWriter, r *http.Request) {
+			serveJSON(w, uncounted)
+		})
+	}
+
+	if *metricsFile != "" {
+		interval, err := time.ParseDuration(*metricsFileInterval)
+		if err != nil {
+			log.Fatalf("Invalid -metrics_file_interval: %v", err)
+		}
+		go writeMetricsFileEvery(uncounted, *metricsFile, interval)
+	}
+	if err := serve(strings.Split(*addr, ","), prefix); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// endpointBase returns the base URL that RPC names are appended to for the
+// endpoint name at u. A unix socket is registered in unixSockets under a
+// placeholder host that the transport dials as the socket.
+func endpointBase(name string, u *url.URL) (string, error) {
+	switch u.Scheme {
+	case "unix":
+		host := name + ".sock"
+		unixSockets[host] = u.Path
+		log.Printf("Using unix socket %s for %s", u.Path, name)
+		return "https://" + host, nil
+	case "https":
+		// RPC names are appended with a slash
+		return strings.TrimRight(u.String(), "/"), nil
+	}
+	return "", fmt.Errorf("endpoint URL does not start with https:// or unix://, endpoint SSL is mandatory: %s", u)
+}
+
+// applyLegacyURL applies the deprecated -url flag to -full_node, unless
+// -full_node was given as well.
+func applyLegacyURL(fs *flag.FlagSet) {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if !set["url"] {
+		return
+	}
+	if set["full_node"] {
+		log.Print("Both -url and -full_node given, ignoring deprecated -url")
+		return
+	}
+	log.Print("-url is deprecated, use -full_node instead")
+	*full_node = *legacyURL
+}
+
+const envPrefix = "CHIA_EXPORTER_"
+
+// applyNetwork points the default -cert and -key at the -network's directory.
+// Flags given explicitly are left alone.
+func applyNetwork(fs *flag.FlagSet) {
+	if _, ok := networks[*network]; !ok {
+		log.Fatalf("Invalid -network %q, must be mainnet or testnet11", *network)
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name, v := range map[string]*string{"cert": cert, "key": key} {
+		if !set[name] {
+			*v = strings.Replace(*v, "/.chia/mainnet/", "/.chia/"+*network+"/", 1)
+		}
+	}
+}
+
+// flagsFromEnv sets each flag of fs not given on the command line from its
+// environment variable, named by envPrefix and the upper cased flag name.
+func flagsFromEnv(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		env := envPrefix + strings.ToUpper(f.Name)
+		if v, ok := os.LookupEnv(env); ok {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", v, env, e)
+			}
+		}
+	})
+	return err
+}
+
+// debugf logs only when -verbose is set.
+func debugf(format string, v ...interface{}) {
+	if *verbose {
+		log.Printf(format, v...)
+	}
+}
+
+// metricsHandler serves the metrics of reg in the format the client accepts,
+// text or OpenMetrics, and gzips them for clients sending Accept-Encoding:
+// gzip, which Prometheus does.
+func metricsHandler(reg *prometheus.Registry) http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		reg, promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	)
+}
+
+// writeMetricsFileEvery writes the metrics gathered from g to path every
+// interval.
+func writeMetricsFileEvery(g prometheus.Gatherer, path string, interval time.Duration) {
+	for {
+		if err := writeMetricsFile(g, path); err != nil {
+			log.Printf("error writing metrics file: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// writeMetricsFile writes the metrics gathered from g to path in the text
+// format. It writes a temporary file next to it first and renames it over
+// path, so that readers never see a partial file.
+func writeMetricsFile(g prometheus.Gatherer, path string) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		// Partial results are still written, like promhttp would serve them
+		log.Printf("error gathering metrics: %v", err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(f, mf); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// TempFile creates the file 0600, but it is meant to be read by others,
+	// such as the node_exporter textfile collector.
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// serve listens on each of addrs and serves the default mux on all of them
+// until one fails or the process is signalled, then shuts them all down. It
+// returns the error of the failed listener, or nil when signalled.
+func serve(addrs []string, prefix string) error {
+	var listeners []net.Listener
+	for _, a := range addrs {
+		a = strings.TrimSpace(a)
+		if _, _, err := net.SplitHostPort(a); err != nil {
+			log.Fatalf("Invalid listen address %q: %v", a, err)
+		}
+		l, err := net.Listen("tcp", a)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	errs := make(chan error, len(listeners))
+	servers := make([]*http.Server, len(listeners))
+	for i, l := range listeners {
+		servers[i] = &http.Server{}
+		log.Printf("Listening on %s. Serving metrics on %s/metrics.", l.Addr(), prefix)
+		go func(s *http.Server, l net.Listener) {
+			errs <- s.Serve(l)
+		}(servers[i], l)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	var err error
+	select {
+	case s := <-sig:
+		log.Printf("Received %s, shutting down", s)
+	case err = <-errs:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Print(err)
+		}
+	}
+	return err
+}
+
+type jsonMetric struct {
+	Labels    map[string]string `json:"labels"`
+	Value     float64           `json:"value"`
+	Count     *uint64           `json:"count,omitempty"`
+	Timestamp int64             `json:"timestamp_ms"`
+}
+
+type jsonMetricFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Metrics []jsonMetric `json:"metrics"`
+}
+
+// serveJSON gathers all metrics from g and writes them to w as a JSON array of
+// metric families. Summaries and histograms are reported by their sample sum
+// and count. Metrics without an explicit timestamp are stamped with the time
+// of the gather.
+func serveJSON(w http.ResponseWriter, g prometheus.Gatherer) {
+	mfs, err := g.Gather()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	families := make([]jsonMetricFamily, 0, len(mfs))
+	for _, mf := range mfs {
+		f := jsonMetricFamily{
+			Name:    mf.GetName(),
+			Help:    mf.GetHelp(),
+			Type:    strings.ToLower(mf.GetType().String()),
+			Metrics: make([]jsonMetric, 0, len(mf.Metric)),
+		}
+		for _, m := range mf.Metric {
+			jm := jsonMetric{
+				Labels:    make(map[string]string, len(m.Label)),
+				Timestamp: now,
+			}
+			for _, l := range m.Label {
+				jm.Labels[l.GetName()] = l.GetValue()
+			}
+			if m.TimestampMs != nil {
+				jm.Timestamp = m.GetTimestampMs()
+			}
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				jm.Value = m.GetGauge().GetValue()
+			case dto.MetricType_COUNTER:
+				jm.Value = m.GetCounter().GetValue()
+			case dto.MetricType_UNTYPED:
+				jm.Value = m.GetUntyped().GetValue()
+			case dto.MetricType_SUMMARY:
+				jm.Value = m.GetSummary().GetSampleSum()
+				c := m.GetSummary().GetSampleCount()
+				jm.Count = &c
+			case dto.MetricType_HISTOGRAM:
+				jm.Value = m.GetHistogram().GetSampleSum()
+				c := m.GetHistogram().GetSampleCount()
+				jm.Count = &c
+			}
+			f.Metrics = append(f.Metrics, jm)
+		}
+		families = append(families, f)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(families); err != nil {
+		log.Print(err)
+	}
+}
+
+// newProxyFunc returns a transport Proxy function using the proxy configuration
+// from the environment, overridden by -proxy, and by the per endpoint proxies
+// in overrides keyed by endpoint base URL. NO_PROXY applies to all of them.
+func newProxyFunc(overrides map[string]string) (func(*http.Request) (*url.URL, error), error) {
+	env := httpproxy.FromEnvironment()
+	if *proxy != "" {
+		env.HTTPProxy = *proxy
+		env.HTTPSProxy = *proxy
+	}
+	def := env.ProxyFunc()
+	funcs := make(map[string]func(*url.URL) (*url.URL, error))
+	for base, p := range overrides {
+		if p == "" || base == "disabled" {
+			continue
+		}
+		if _, err := url.Parse(p); err != nil {
+			return nil, fmt.Errorf("invalid proxy for %s: %w", base, err)
+		}
+		u, err := url.Parse(base)
+		if err != nil {
+			return nil, err
+		}
+		c := *env
+		c.HTTPProxy = p
+		c.HTTPSProxy = p
+		funcs[u.Host] = c.ProxyFunc()
+	}
+	return func(r *http.Request) (*url.URL, error) {
+		if _, ok := unixSockets[r.URL.Hostname()]; ok {
+			return nil, nil
+		}
+		if f, ok := funcs[r.URL.Host]; ok {
+			return f(r.URL)
+		}
+		return def(r.URL)
+	}, nil
+}
+
+// unixSockets maps placeholder endpoint hosts to the unix socket paths they
+// are dialed as.
+var unixSockets = map[string]string{}
+
+// socksDialers maps endpoint addresses to the SOCKS5 proxy they are dialed
+// through, or nil to dial them directly. Other addresses are dialed through
+// defaultSocks, if set.
+var (
+	socksDialers = map[string]netproxy.ContextDialer{}
+	defaultSocks netproxy.ContextDialer
+)
+
+// setupSocks sets up -socks5 and the per endpoint overrides, given by base URL.
+func setupSocks(overrides map[string]string) error {
+	var err error
+	if defaultSocks, err = newSocksDialer(*socks5); err != nil {
+		return err
+	}
+	for base, addr := range overrides {
+		if addr == "" || base == "disabled" {
+			continue
+		}
+		u, err := url.Parse(base)
+		if err != nil {
+			return err
+		}
+		port := u.Port()
+		if port == "" {
+			port = "443"
+		}
+		if socksDialers[net.JoinHostPort(u.Hostname(), port)], err = newSocksDialer(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newSocksDialer returns a dialer through the SOCKS5 proxy at addr, or nil for
+// an empty addr or "direct". Host names are resolved by the proxy.
+func newSocksDialer(addr string) (netproxy.ContextDialer, error) {
+	if addr == "" || addr == "direct" {
+		return nil, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid SOCKS5 proxy %q, must be host:port: %w", addr, err)
+	}
+	d, err := netproxy.SOCKS5("tcp", addr, nil, &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOCKS5 proxy %q: %w", addr, err)
+	}
+	return d.(netproxy.ContextDialer), nil
+}
+
+// dialContext dials addr, or the unix socket it stands for, through its
+// SOCKS5 proxy if any.
+func dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if path, ok := unixSockets[host]; ok {
+			return d.DialContext(ctx, "unix", path)
+		}
+	}
+	socks, ok := socksDialers[addr]
+	if !ok {
+		socks = defaultSocks
+	}
+	if socks != nil {
+		return socks.DialContext(ctx, network, addr)
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// checkReadable reports a missing or unreadable SSL file with a hint, as the
+// error from loading the key pair does not say which file was the problem.
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		return fmt.Errorf("SSL file %s does not exist, check the certificate and key flags", path)
+	case os.IsPermission(err):
+		return fmt.Errorf("SSL file %s is not readable by the exporter's user, check its permissions", path)
+	case err != nil:
+		return err
+	}
+	return f.Close()
+}
+
+// parseHostCerts parses -host_certs into the certificate and key paths by
+// host.
+func parseHostCerts(s string) (map[string][2]string, error) {
+	certs := make(map[string][2]string)
+	if s == "" {
+		return certs, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		host, pair := splitPair(entry, "=")
+		c, k := splitCertKey(pair)
+		if host == "" || c == "" || k == "" {
+			return nil, fmt.Errorf("invalid -host_certs entry %q, must be host=cert:key", entry)
+		}
+		// Hosts are matched without brackets, like url.URL.Hostname
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		certs[host] = [2]string{os.ExpandEnv(c), os.ExpandEnv(k)}
+	}
+	return certs, nil
+}
+
+// splitPair splits s around the first sep, returning an empty second part if
+// there is none.
+func splitPair(s, sep string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(s), sep, 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// splitCertKey splits cert:key around the first colon that doesn't follow a
+// Windows drive letter, so that C:\ssl\node.crt:C:\ssl\node.key splits
+// between the paths.
+func splitCertKey(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	for i := 0; i < len(s); i++ {
+		if s[i] != ':' {
+			continue
+		}
+		if i == 1 && len(s) > 2 && (s[2] == '\\' || s[2] == '/') {
+			continue
+		}
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// newClient returns a client authenticating with the given certificate and
+// key, along with the parsed leaf certificate. The server's certificate is
+// verified against serverName if given, otherwise against the host of the
+// request.
+func newClient(cert, key, serverName string, proxy func(*http.Request) (*url.URL, error)) (*http.Client, *x509.Certificate, error) {
+	for _, f := range []string{cert, key} {
+		if err := checkReadable(f); err != nil {
+			return nil, nil, err
+		}
+	}
+	c, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading certificate %s with key %s, check that both are PEM encoded and that the key belongs to the certificate: %w", cert, key, err)
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing certificate %s: %w", cert, err)
+	}
+	c.Leaf = leaf
+	to, err := time.ParseDuration(*timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	idle, err := time.ParseDuration(*idleConnTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	minVersion, err := tlsVersion(*minTLSVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	var roots *x509.CertPool
+	if !*insecure && *ca != "" {
+		pem, err := ioutil.ReadFile(os.ExpandEnv(*ca))
+		if err != nil {
+			return nil, nil, err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in %s", *ca)
+		}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 proxy,
+			DialContext:           dialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          *maxIdleConns,
+			IdleConnTimeout:       idle,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			TLSClientConfig: &tls.Config{
+				Certificates:       []tls.Certificate{c},
+				RootCAs:            roots,
+				ServerName:         serverName,
+				InsecureSkipVerify: *insecure,
+				MinVersion:         minVersion,
+			},
+		},
+		Timeout: to,
+	}, leaf, nil
+}
+
+// tlsVersion parses a -min_tls_version. An empty version leaves the minimum
+// to the default of Go.
+func tlsVersion(v string) (uint16, error) {
+	switch v {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("invalid -min_tls_version %q, must be 1.0, 1.1, 1.2 or 1.3", v)
+}
+
+// callAPI posts query to endpoint and returns the response, or fails when ctx
+// is done. The caller must close the response body.
+func callAPI(ctx context.Context, client *http.Client, base, endpoint, query string) (*http.Response, error) {
+	if query == "" {
+		query = `{"":""}`
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/"+endpoint, strings.NewReader(query))
+	if err != nil {
+		return nil, &queryError{"config", fmt.Errorf("error calling %s: %w", endpoint, err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	ua := *userAgent
+	if ua == "" {
+		ua = "chia_exporter/" + Version
+	}
+	req.Header.Set("User-Agent", ua)
+	r, err := client.Do(req)
+	if err != nil {
+		return nil, &queryError{clientErrorStage(err), fmt.Errorf("error calling %s: %w", endpoint, err)}
+	}
+	return r, nil
+}
+
+// errNoRoute is returned for an RPC the endpoint answers 404 for, as Chia
+// services do for routes they don't have, e.g. on an older version.
+var errNoRoute = errors.New("no such route")
+
+// queryError is an error of an RPC call with the stage it failed in, for
+// chia_exporter_errors_total.
+type queryError struct {
+	stage string
+	err   error
+}
+
+func (e *queryError) Error() string { return e.err.Error() }
+
+func (e *queryError) Unwrap() error { return e.err }
+
+// clientErrorStage tells certificate and handshake failures apart from other
+// failures to reach an endpoint.
+func clientErrorStage(err error) string {
+	var (
+		authority x509.UnknownAuthorityError
+		invalid   x509.CertificateInvalidError
+		hostname  x509.HostnameError
+		header    tls.RecordHeaderError
+	)
+	switch {
+	case errors.As(err, &authority), errors.As(err, &invalid), errors.As(err, &hostname), errors.As(err, &header):
+		return "tls"
+	case strings.Contains(err.Error(), "tls: "):
+		// TLS alerts from the endpoint are not exported as types
+		return "tls"
+	}
+	return "network"
+}
+
+// queryAPI posts query to endpoint and decodes the response into result. It
+// returns the number of response bytes read, also when it fails.
+func queryAPI(ctx context.Context, client *http.Client, base, endpoint, query string, result interface{}) (int64, error) {
+	r, err := callAPI(ctx, client, base, endpoint, query)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+	var n byteCounter
+	t := io.TeeReader(io.LimitReader(r.Body, *maxResponseBytes+1), &n)
+	// Read the whole body before decoding so that a connection dropped
+	// mid-response is reported apart from a malformed response.
+	body, err := ioutil.ReadAll(t)
+	if err != nil {
+		return int64(n), &queryError{"network", fmt.Errorf("error reading %s response after %d bytes: %w", endpoint, len(body), err)}
+	}
+	if int64(len(body)) > *maxResponseBytes {
+		return int64(n), &queryError{"decode", fmt.Errorf("error reading %s response: larger than -max_response_bytes=%d", endpoint, *maxResponseBytes)}
+	}
+	if isHTML(r.Header.Get("Content-Type"), body) {
+		return int64(n), &queryError{"decode", fmt.Errorf("error decoding %s response: got HTML instead of JSON, check that %s is the RPC port of the service", endpoint, base)}
+	}
+	if r.StatusCode == http.StatusNotFound {
+		return int64(n), fmt.Errorf("%s: %w", endpoint, errNoRoute)
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return int64(n), &queryError{"decode", fmt.Errorf("error decoding %s response of %d bytes: %w", endpoint, len(body), err)}
+	}
+	return int64(n), nil
+}
+
+// streamAPI posts query to endpoint and passes the response body to decode
+// as it is read, for responses too large to hold in memory. It applies the
+// checks of queryAPI and returns the number of response bytes read.
+func streamAPI(ctx context.Context, client *http.Client, base, endpoint, query string, decode func(io.Reader) error) (int64, error) {
+	r, err := callAPI(ctx, client, base, endpoint, query)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+	var n byteCounter
+	body := &errReader{r: io.TeeReader(io.LimitReader(r.Body, *maxResponseBytes+1), &n)}
+	br := bufio.NewReader(body)
+	// A short response fails the peek, which is left to decode to report
+	head, _ := br.Peek(512)
+	if isHTML(r.Header.Get("Content-Type"), head) {
+		return int64(n), &queryError{"decode", fmt.Errorf("error decoding %s response: got HTML instead of JSON, check that %s is the RPC port of the service", endpoint, base)}
+	}
+	if r.StatusCode == http.StatusNotFound {
+		return int64(n), fmt.Errorf("%s: %w", endpoint, errNoRoute)
+	}
+	if err := decode(br); err != nil {
+		switch {
+		case body.err != nil:
+			return int64(n), &queryError{"network", fmt.Errorf("error reading %s response after %d bytes: %w", endpoint, n, body.err)}
+		case int64(n) > *maxResponseBytes:
+			return int64(n), &queryError{"decode", fmt.Errorf("error reading %s response: larger than -max_response_bytes=%d", endpoint, *maxResponseBytes)}
+		}
+		return int64(n), &queryError{"decode", fmt.Errorf("error decoding %s response: %w", endpoint, err)}
+	}
+	return int64(n), nil
+}
+
+// errReader records the first error reading from r other than io.EOF, to tell
+// a dropped connection apart from a malformed response.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// byteCounter is a writer counting the bytes written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// isHTML reports whether a response looks like a web page, as served when an
+// endpoint points at the wrong port.
+func isHTML(contentType string, body []byte) bool {
+	if strings.HasPrefix(contentType, "text/html") {
+		return true
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(body), []byte("<"))
+}
+
+// check pings each enabled endpoint with get_version and writes a report to
+// w. It returns whether all of them answered.
+func (cc *ChiaCollector) check(w io.Writer) bool {
+	ok := true
+	for _, e := range cc.endpoints() {
+		if e.base == "disabled" {
+			fmt.Fprintf(w, "%-10s disabled\n", e.name)
+			continue
+		}
+		var v struct {
+			Version string
+			Success bool
+			Error   string
+		}
+		err := cc.query(e.base, "get_version", "", &v)
+		if err == nil && !v.Success {
+			err = fmt.Errorf("get_version failed: %s", v.Error)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%-10s FAIL %s: %v\n", e.name, e.base, err)
+			ok = false
+			continue
+		}
+		fmt.Fprintf(w, "%-10s ok   %s version %s\n", e.name, e.base, v.Version)
+	}
+	return ok
+}
+
+// debugRPCs are the RPCs /debug may proxy for each service: the read-only
+// ones the collectors call.
+var debugRPCs = map[string]map[string]bool{
+	"full_node": {
+		"get_all_mempool_items": true,
+		"get_blockchain_state":  true,
+		"get_connections":       true,
+		"get_network_info":      true,
+		"get_routes":            true,
+		"get_version":           true,
+	},
+	"wallet": {
+		"cat_asset_id_to_name": true,
+		"cat_get_asset_id":     true,
+		"get_cat_list":         true,
+		"get_connections":      true,
+		"get_farmed_amount":    true,
+		"get_height_info":      true,
+		"get_notifications":    true,
+		"get_routes":           true,
+		"get_sync_status":      true,
+		"get_transactions":     true,
+		"get_version":          true,
+		"get_wallet_balance":   true,
+		"get_wallets":          true,
+		"pw_status":            true,
+	},
+	"farmer": {
+		"get_connections": true,
+		"get_harvesters":  true,
+		"get_pool_state":  true,
+		"get_routes":      true,
+		"get_version":     true,
+	},
+	"harvester": {
+		"get_plot_directories": true,
+		"get_plots":            true,
+		"get_routes":           true,
+		"get_version":          true,
+	},
+}
+
+// serveDebug proxies GET /debug/<service>/<rpc> to the corresponding Chia RPC
+// and writes the raw response. Only the RPCs in debugRPCs are allowed. Integer
+// URL parameters, such as ?wallet_id=1, are passed on as the query.
+func (cc *ChiaCollector) serveDebug(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/debug/"), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		http.Error(w, "usage: /debug/<full_node|wallet|farmer|harvester>/<rpc>", http.StatusNotFound)
+		return
+	}
+	base := map[string]string{
+		"full_node": cc.full_nodeURL,
+		"wallet":    cc.walletURL,
+		"farmer":    cc.farmerURL,
+		"harvester": cc.harvesterURL,
+	}[parts[0]]
+	if base == "" || base == "disabled" {
+		http.Error(w, "unknown or disabled service "+parts[0], http.StatusNotFound)
+		return
+	}
+	if !debugRPCs[parts[0]][parts[1]] {
+		http.Error(w, "rpc "+parts[1]+" is not allowed", http.StatusForbidden)
+		return
+	}
+	params := map[string]int64{}
+	for k, vs := range r.URL.Query() {
+		v, err := strconv.ParseInt(vs[len(vs)-1], 10, 64)
+		if err != nil {
+			http.Error(w, "parameter "+k+" is not an integer", http.StatusBadRequest)
+			return
+		}
+		params[k] = v
+	}
+	q, err := json.Marshal(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := callAPI(r.Context(), cc.clientFor(base), base, parts[1], string(q))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Print(err)
+	}
+}
+
+type ChiaCollector struct {
+	client       *http.Client
+	full_nodeURL string
+	walletURL    string
+	farmerURL    string
+	harvesterURL string
+
+	// clients overrides client for the endpoints with their own SSL
+	// material, keyed by base URL.
+	clients map[string]*http.Client
+	// certExpiry is the expiry time of each certificate by path.
+	certExpiry map[string]time.Time
+	// routes are the RPC routes of each endpoint by base URL, if discovered
+	// with -discover_routes. Only written before collection starts.
+	routes map[string]map[string]bool
+	// harvesterStaleAfter is how long since its last plot sync a harvester
+	// is considered disconnected.
+	harvesterStaleAfter time.Duration
+	// consensus holds the consensus constants of -network.
+	consensus Consensus
+	// minScrapeInterval is how long the metrics of a scrape are served
+	// from cache, or 0 to not cache them.
+	minScrapeInterval time.Duration
+	// scrapeTimeout bounds the time a scrape waits for metrics, or 0.
+	scrapeTimeout time.Duration
+
+	// rounds holds a token while a scrape is collecting, so that scrapes
+	// don't overlap and mix their results.
+	rounds chan struct{}
+
+	// cacheMu guards the cached metrics and serializes scrapes when caching.
+	cacheMu  sync.Mutex
+	cached   []prometheus.Metric
+	cachedAt time.Time
+
+	// ready is set once any query has succeeded, accessed atomically.
+	ready uint32
+	// scrapes counts the scrapes of /metrics, accessed atomically.
+	scrapes uint64
+
+	// mu guards the state below, which is carried across scrapes.
+	mu           sync.Mutex
+	plotCount    int
+	plotsSeen    bool
+	plotsAdded   float64
+	plotsRemoved float64
+
+	walletDownReason string
+	// network reported by the full node at the previous scrape
+	nodeNetwork string
+
+	// peak height at the previous scrape and blocks the peak advanced by
+	peakHeight      int
+	peakSeen        bool
+	blocksValidated float64
+
+	// context of the queries of the current scrape, whether any query
+	// succeeded in it per base URL queried and the endpoints it finished,
+	// nil outside a scrape
+	roundCtx  context.Context
+	roundOK   map[string]bool
+	roundDone map[string]bool
+	// result of the latest scrape and number of scrapes in a row it failed
+	// per base URL
+	endpointOK          map[string]bool
+	consecutiveFailures map[string]int
+	// size of the latest response per base URL and rpc
+	responseBytes map[rpcKey]int64
+	// rpcs an endpoint answered 404 for
+	missingRoutes map[rpcKey]bool
+	// errors since startup per stage
+	stageErrors map[string]float64
+
+	// previous difficulty and number of changes per pool launcher id
+	poolDifficulty        map[string]int64
+	poolDifficultyChanges map[string]float64
+	// points at the previous scrape and points earned across payouts per
+	// pool launcher id
+	poolPoints       map[string]poolPoints
+	poolPointsEarned map[string]float64
+}
+
+// rpcKey identifies an rpc of the endpoint at base.
+type rpcKey struct {
+	base, rpc string
+}
+
+// endpoint is a Chia service by name and its base URL, or "disabled".
+type endpoint struct {
+	name, base string
+}
+
+func (cc *ChiaCollector) endpoints() []endpoint {
+	return []endpoint{
+		{"full_node", cc.full_nodeURL},
+		{"wallet", cc.walletURL},
+		{"farmer", cc.farmerURL},
+		{"harvester", cc.harvesterURL},
+	}
+}
+
+// endpointUp returns the result of the latest scrape of the endpoint at base,
+// and whether it has been scraped at all.
+func (cc *ChiaCollector) endpointUp(base string) (up, seen bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	up, seen = cc.endpointOK[base]
+	return up, seen
+}
+
+// startRound starts recording the results of the queries of a scrape, which
+// are made with ctx.
+func (cc *ChiaCollector) startRound(ctx context.Context) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.roundCtx = ctx
+	cc.roundOK = make(map[string]bool)
+	cc.roundDone = make(map[string]bool)
+}
+
+// roundContext returns the context to make queries with, that of the current
+// scrape if any.
+func (cc *ChiaCollector) roundContext() context.Context {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.roundCtx == nil {
+		return context.Background()
+	}
+	return cc.roundCtx
+}
+
+// endpointDone records that the current scrape finished collecting the
+// endpoint at base.
+func (cc *ChiaCollector) endpointDone(base string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.roundDone != nil {
+		cc.roundDone[base] = true
+	}
+}
+
+// endRound sets the result of the scrape of each enabled endpoint: up if it
+// finished and any query to it succeeded, so that an rpc some versions lack
+// doesn't take it down. If counted, it also counts the scrapes in a row each
+// endpoint failed. Queries still running after it are not recorded.
+func (cc *ChiaCollector) endRound(counted bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for _, e := range cc.endpoints() {
+		if e.base == "disabled" {
+			continue
+		}
+		ok := cc.roundOK[e.base] && cc.roundDone[e.base]
+		cc.endpointOK[e.base] = ok
+		switch {
+		case !counted:
+		case ok:
+			cc.consecutiveFailures[e.base] = 0
+		default:
+			cc.consecutiveFailures[e.base]++
+		}
+	}
+	cc.roundOK = nil
+	cc.roundDone = nil
+}
+
+var endpointUpDesc = prometheus.NewDesc(
+	"chia_endpoint_up",
+	"Whether any query to the endpoint succeeded in the latest scrape.",
+	[]string{"endpoint"}, nil,
+)
+
+var endpointConsecutiveFailuresDesc = prometheus.NewDesc(
+	"chia_endpoint_consecutive_failures",
+	"Number of scrapes in a row in which no query to the endpoint succeeded.",
+	[]string{"endpoint"}, nil,
+)
+
+// collectEndpointUp reports the result of the latest scrape of each enabled
+// endpoint that has been scraped, and the scrapes in a row that it failed.
+func (cc *ChiaCollector) collectEndpointUp(ch chan<- prometheus.Metric) {
+	for _, e := range cc.endpoints() {
+		up, seen := cc.endpointUp(e.base)
+		if e.base == "disabled" || !seen {
+			continue
+		}
+		var v float64
+		if up {
+			v = 1
+		}
+		cc.mu.Lock()
+		failures := cc.consecutiveFailures[e.base]
+		cc.mu.Unlock()
+		ch <- prometheus.MustNewConstMetric(
+			endpointUpDesc,
+			prometheus.GaugeValue,
+			v,
+			e.name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			endpointConsecutiveFailuresDesc,
+			prometheus.GaugeValue,
+			float64(failures),
+			e.name,
+		)
+	}
+}
+
+var rpcResponseBytesDesc = prometheus.NewDesc(
+	"chia_rpc_response_bytes",
+	"Size of the latest response of an rpc of the endpoint, in bytes.",
+	[]string{"endpoint", "rpc"}, nil,
+)
+
+// collectResponseBytes reports the size of the latest response of each rpc
+// called so far, e.g. to spot get_harvesters growing large on a big farm.
+func (cc *ChiaCollector) collectResponseBytes(ch chan<- prometheus.Metric) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for _, e := range cc.endpoints() {
+		for k, n := range cc.responseBytes {
+			if k.base != e.base {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				rpcResponseBytesDesc,
+				prometheus.GaugeValue,
+				float64(n),
+				e.name, k.rpc,
+			)
+		}
+	}
+}
+
+var exporterErrorsDesc = prometheus.NewDesc(
+	"chia_exporter_errors_total",
+	"Number of errors of the exporter by stage, config, tls, decode or network.",
+	[]string{"stage"}, nil,
+)
+
+// errorStages are the stages errors are counted by, all of which are reported
+// so that each series exists before its first error.
+var errorStages = []string{"config", "tls", "decode", "network"}
+
+// collectErrors reports the errors counted since startup.
+func (cc *ChiaCollector) collectErrors(ch chan<- prometheus.Metric) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for _, stage := range errorStages {
+		ch <- prometheus.MustNewConstMetric(
+			exporterErrorsDesc,
+			prometheus.CounterValue,
+			cc.stageErrors[stage],
+			stage,
+		)
+	}
+}
+
+// writeStatus writes the status of each endpoint for the root page.
+func (cc *ChiaCollector) writeStatus(w io.Writer) {
+	fmt.Fprintf(w, "Endpoints:\n")
+	for _, e := range cc.endpoints() {
+		status := "disabled"
+		if e.base != "disabled" {
+			up, seen := cc.endpointUp(e.base)
+			switch {
+			case !seen:
+				status = "not scraped yet"
+			case up:
+				status = "up"
+			default:
+				status = "down"
+			}
+		}
+		fmt.Fprintf(w, "  %-10s %s\n", e.name, status)
+	}
+}
+
+// optionalCollectors are the collectors that are skipped when the RPC they
+// query is missing from an endpoint's routes, by the service that serves it.
+var optionalCollectors = []struct {
+	name, service, rpc string
+}{
+	{"mempool_items", "full_node", "get_all_mempool_items"},
+	{"notifications", "wallet", "get_notifications"},
+	{"pool_state", "farmer", "get_pool_state"},
+	{"farmer_harvesters", "farmer", "get_harvesters"},
+	{"plot_directories", "harvester", "get_plot_directories"},
+}
+
+// discoverRoutes asks each enabled endpoint for its RPC routes. Endpoints
+// that fail to answer are assumed to support everything.
+func (cc *ChiaCollector) discoverRoutes() {
+	cc.routes = make(map[string]map[string]bool)
+	for _, e := range cc.endpoints() {
+		if e.base == "disabled" {
+			continue
+		}
+		var r Routes
+		err := cc.query(e.base, "get_routes", "", &r)
+		if err == nil && !r.Success {
+			err = fmt.Errorf("get_routes failed")
+		}
+		if err != nil {
+			log.Printf("Could not discover routes of %s, assuming all are available: %v", e.name, err)
+			continue
+		}
+		routes := make(map[string]bool, len(r.Routes))
+		for _, route := range r.Routes {
+			routes[strings.TrimPrefix(route, "/")] = true
+		}
+		cc.routes[e.base] = routes
+		for _, c := range optionalCollectors {
+			if c.service == e.name && !routes[c.rpc] {
+				log.Printf("Disabling %s collector, %s has no %s route", c.name, e.name, c.rpc)
+			}
+		}
+	}
+}
+
+// available reports whether the endpoint at base serves rpc. It does unless
+// its routes were discovered and rpc is not among them, or it answered 404 for
+// rpc before.
+func (cc *ChiaCollector) available(base, rpc string) bool {
+	cc.mu.Lock()
+	missing := cc.missingRoutes[rpcKey{base, rpc}]
+	cc.mu.Unlock()
+	routes, ok := cc.routes[base]
+	return !missing && (!ok || routes[rpc])
+}
+
+var collectorAvailableDesc = prometheus.NewDesc(
+	"chia_collector_available",
+	"Whether the RPC queried by an optional collector is among the routes of its endpoint.",
+	[]string{"collector"}, nil,
+)
+
+// collectAvailable reports the optional collectors of the endpoints whose
+// routes were discovered.
+func (cc *ChiaCollector) collectAvailable(ch chan<- prometheus.Metric) {
+	for _, e := range cc.endpoints() {
+		if _, ok := cc.routes[e.base]; !ok {
+			continue
+		}
+		for _, c := range optionalCollectors {
+			if c.service != e.name {
+				continue
+			}
+			var v float64
+			if cc.available(e.base, c.rpc) {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				collectorAvailableDesc,
+				prometheus.GaugeValue,
+				v,
+				c.name,
+			)
+		}
+	}
+}
+
+// clientFor returns the client to use for the endpoint at base.
+func (cc *ChiaCollector) clientFor(base string) *http.Client {
+	if c, ok := cc.clients[base]; ok {
+		return c
+	}
+	return cc.client
+}
+
+// query calls queryAPI with the endpoint's client and records the result for
+// readiness and endpoint status, and the size of the response.
+func (cc *ChiaCollector) query(base, endpoint, query string, result interface{}) error {
+	n, err := queryAPI(cc.roundContext(), cc.clientFor(base), base, endpoint, query, result)
+	return cc.record(base, endpoint, n, err)
+}
+
+// queryStream is query for responses decoded by decode as they are read, with
+// streamAPI.
+func (cc *ChiaCollector) queryStream(base, endpoint, query string, decode func(io.Reader) error) error {
+	n, err := streamAPI(cc.roundContext(), cc.clientFor(base), base, endpoint, query, decode)
+	return cc.record(base, endpoint, n, err)
+}
+
+// record records the result of a query of n response bytes, and returns its
+// error.
+func (cc *ChiaCollector) record(base, endpoint string, n int64, err error) error {
+	// A missing route says nothing about the endpoint, and is remembered so
+	// that available skips it from now on
+	if errors.Is(err, errNoRoute) {
+		cc.mu.Lock()
+		seen := cc.missingRoutes[rpcKey{base, endpoint}]
+		cc.missingRoutes[rpcKey{base, endpoint}] = true
+		cc.mu.Unlock()
+		if !seen {
+			log.Printf("%s has no %s route, skipping it from now on", base, endpoint)
+		}
+		return err
+	}
+	cc.mu.Lock()
+	// Queries cancelled at -scrape_timeout are not errors of the endpoint
+	if err != nil && cc.roundCtx != nil && cc.roundCtx.Err() != nil {
+		cc.mu.Unlock()
+		return err
+	}
+	if cc.roundOK != nil {
+		cc.roundOK[base] = cc.roundOK[base] || err == nil
+	}
+	if n > 0 {
+		cc.responseBytes[rpcKey{base, endpoint}] = n
+	}
+	var qe *queryError
+	if errors.As(err, &qe) {
+		cc.stageErrors[qe.stage]++
+	}
+	cc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	atomic.StoreUint32(&cc.ready, 1)
+	return nil
+}
+
+// Ready reports whether at least one endpoint has been scraped successfully.
+func (cc *ChiaCollector) Ready() bool {
+	return atomic.LoadUint32(&cc.ready) == 1
+}
+
+// Describe sends no descriptors, which makes cc an unchecked collector. The
+// metrics depend on what the endpoints report, and describing them by
+// collecting would scrape every endpoint on registration.
+func (cc *ChiaCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+// startTime is set once when the exporter starts and emitted on every scrape.
+var startTime = prometheus.MustNewConstMetric(
+	prometheus.NewDesc(
+		"chia_exporter_start_time_seconds",
+		"Time the exporter was started, in seconds since the epoch.",
+		nil, nil,
+	),
+	prometheus.GaugeValue,
+	float64(time.Now().Unix()),
+)
+
+var scrapesDesc = prometheus.NewDesc(
+	"chia_exporter_scrapes_total",
+	"Number of scrapes of /metrics, including those served from the -min_scrape_interval cache.",
+	nil, nil,
+)
+
+var enabledEndpointsDesc = prometheus.NewDesc(
+	"chia_exporter_enabled_endpoints",
+	"Number of RPC endpoints the exporter is configured to query, not disabled.",
+	nil, nil,
+)
+
+var certExpiryDesc = prometheus.NewDesc(
+	"chia_cert_expiry_timestamp_seconds",
+	"Expiry time of the SSL certificate used to query Chia, in seconds since the epoch.",
+	[]string{"cert"}, nil,
+)
+
+// Collect queries the endpoints, or with -min_scrape_interval replays the
+// metrics of the previous scrape if it was recent enough.
+func (cc *ChiaCollector) Collect(ch chan<- prometheus.Metric) {
+	cc.collectCached(ch, true)
+}
+
+// uncountedCollector collects cc for gathers other than scrapes of /metrics,
+// such as /metrics.json and -metrics_file, which don't count towards
+// chia_exporter_scrapes_total and chia_endpoint_consecutive_failures.
+type uncountedCollector struct {
+	*ChiaCollector
+}
+
+func (u uncountedCollector) Collect(ch chan<- prometheus.Metric) {
+	u.collectCached(ch, false)
+}
+
+// collectCached is Collect, with counted telling whether the scrape counts
+// towards chia_exporter_scrapes_total and chia_endpoint_consecutive_failures.
+func (cc *ChiaCollector) collectCached(ch chan<- prometheus.Metric, counted bool) {
+	scrapes := atomic.LoadUint64(&cc.scrapes)
+	if counted {
+		scrapes = atomic.AddUint64(&cc.scrapes, 1)
+	}
+	var m prometheus.Metric = prometheus.MustNewConstMetric(
+		scrapesDesc,
+		prometheus.CounterValue,
+		float64(scrapes),
+	)
+	if *withTimestamp {
+		m = prometheus.NewMetricWithTimestamp(time.Now(), m)
+	}
+	ch <- m
+	if cc.minScrapeInterval <= 0 {
+		cc.scrape(ch, counted)
+		return
+	}
+	cc.cacheMu.Lock()
+	defer cc.cacheMu.Unlock()
+	if time.Since(cc.cachedAt) >= cc.minScrapeInterval {
+		metrics := make(chan prometheus.Metric)
+		var scraped bool
+		go func() {
+			scraped = cc.scrape(metrics, counted)
+			close(metrics)
+		}()
+		var fresh []prometheus.Metric
+		for m := range metrics {
+			fresh = append(fresh, m)
+		}
+		// A skipped scrape has no metrics, keep serving the previous ones
+		if scraped {
+			cc.cached = fresh
+			cc.cachedAt = time.Now()
+		}
+	} else {
+		debugf("serving metrics cached at %s", cc.cachedAt.Format(time.RFC3339))
+	}
+	for _, m := range cc.cached {
+		ch <- m
+	}
+}
+
+// scrape collects the metrics, with -with_timestamp stamped with the time the
+// collection started. A scrape waits for the previous one to finish, so that
+// they don't overlap. With -scrape_timeout, the queries still running at the
+// deadline are cancelled, the metrics not collected by then are omitted and
+// the endpoints not finished are reported down. It returns false if the scrape
+// was skipped because the previous one was still running at the deadline.
+func (cc *ChiaCollector) scrape(ch chan<- prometheus.Metric, counted bool) bool {
+	ctx := context.Background()
+	if cc.scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cc.scrapeTimeout)
+		defer cancel()
+	}
+	select {
+	case cc.rounds <- struct{}{}:
+	case <-ctx.Done():
+		log.Printf("previous scrape still running after -scrape_timeout=%s, skipping this one", cc.scrapeTimeout)
+		return false
+	}
+	now := time.Now()
+	send := func(m prometheus.Metric) {
+		if *withTimestamp {
+			m = prometheus.NewMetricWithTimestamp(now, m)
+		}
+		ch <- m
+	}
+	cc.startRound(ctx)
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		cc.collect(metrics)
+		close(metrics)
+	}()
+	for {
+		select {
+		case m, ok := <-metrics:
+			if ok {
+				send(m)
+				continue
+			}
+			cc.endRound(counted)
+			<-cc.rounds
+		case <-ctx.Done():
+			log.Printf("scrape exceeded -scrape_timeout=%s, omitting the remaining metrics", cc.scrapeTimeout)
+			cc.endRound(counted)
+			// The cancelled queries fail fast, the next scrape waits
+			// for them
+			go func() {
+				for range metrics {
+				}
+				<-cc.rounds
+			}()
+		}
+		up := make(chan prometheus.Metric, 2*len(cc.endpoints()))
+		cc.collectEndpointUp(up)
+		close(up)
+		for m := range up {
+			send(m)
+		}
+		return true
+	}
+}
+
+func (cc *ChiaCollector) collect(ch chan<- prometheus.Metric) {
+	ch <- startTime
+	for path, t := range cc.certExpiry {
+		ch <- prometheus.MustNewConstMetric(
+			certExpiryDesc,
+			prometheus.GaugeValue,
+			float64(t.Unix()),
+			path,
+		)
+	}
+	var enabled int
+	for _, e := range cc.endpoints() {
+		if e.base != "disabled" {
+			enabled++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(
+		enabledEndpointsDesc,
+		prometheus.GaugeValue,
+		float64(enabled),
+	)
+	// Any endpoint could be set to "disabled" to indicate it's disabled
+	var bs *BlockchainState
+	// health holds the signals of the enabled services for -farm_health
+	var health []bool
+	if cc.full_nodeURL != "disabled" {
+		peers := cc.collectConnections(ch)
+		cc.collectNetworkInfo(ch)
+		bs = cc.collectBlockchainState(ch)
+		health = append(health, peers > 0, bs != nil && bs.BlockchainState.Sync.Synced)
+		if *mempoolDetails && bs != nil && cc.available(cc.full_nodeURL, "get_all_mempool_items") {
+			cc.collectMempoolItems(ch, bs)
+		}
+		cc.endpointDone(cc.full_nodeURL)
+	}
+	if cc.walletURL != "disabled" {
+		health = append(health, cc.collectWallets(ch, bs))
+		cc.collectWalletConnections(ch)
+		if cc.available(cc.walletURL, "get_notifications") {
+			cc.collectNotifications(ch)
+		}
+		cc.endpointDone(cc.walletURL)
+	}
+	// Plots for the derived metrics come from the farmer, which covers all
+	// harvesters, or from the harvester if the farmer's are not collected.
+	var plots []PlotData
+	var farmerPlots bool
+	if cc.farmerURL != "disabled" {
+		if cc.available(cc.farmerURL, "get_pool_state") {
+			ratio := cc.collectPoolState(ch)
+			health = append(health, ratio >= healthMinAckRatio)
+		}
+		if *plotsSource != "harvester" && cc.available(cc.farmerURL, "get_harvesters") {
+			hs := cc.collectFarmerHarvesters(ch)
+			if hs != nil {
+				farmerPlots = true
+				plots = []PlotData{}
+				for _, h := range hs.Harvesters {
+					plots = append(plots, h.Plots...)
+				}
+			}
+			health = append(health, hs != nil && cc.harvestersConnected(hs))
+		}
+		cc.endpointDone(cc.farmerURL)
+	}
+	if cc.harvesterURL != "disabled" {
+		var pf *PlotFiles
+		if *plotsSource != "farmer" {
+			removed := cc.plotsRemovedTotal()
+			if pf = cc.collectPlots(ch); pf != nil && !farmerPlots {
+				plots = pf.Plots
+			}
+			health = append(health, pf != nil && cc.plotsRemovedTotal() == removed)
+		}
+		var dirs []string
+		if cc.available(cc.harvesterURL, "get_plot_directories") {
+			dirs = cc.collectPlotDirectories(ch)
+		}
+		if pf != nil && dirs != nil {
+			collectPlotsPerDirectory(ch, pf.Plots, dirs)
+		}
+		cc.endpointDone(cc.harvesterURL)
 	}
-	prometheus.MustRegister(cc)
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "chia_exporter version %s\n", Version)
-		fmt.Fprintf(w, "metrics are published on /metrics\n\n")
-		fmt.Fprintf(w, "This program is free software released under the GNU AGPL.\n")
-		fmt.Fprintf(w, "The source code is availabe at https://github.com/artanicus/chia_exporter\n")
-	})
-	http.Handle("/metrics", promhttp.Handler())
-
-	log.Printf("Listening on %s. Serving metrics on /metrics.", *addr)
-	log.Fatal(http.ListenAndServe(*addr, nil))
-}
-
-func newClient(cert, key string) (*http.Client, error) {
-	c, err := tls.LoadX509KeyPair(cert, key)
-	if err != nil {
-		return nil, err
+	cc.collectResponseBytes(ch)
+	cc.collectErrors(ch)
+	cc.collectAvailable(ch)
+	if bs != nil && plots != nil {
+		cc.collectExpectedRewards(ch, bs, plots)
 	}
-	to, err := time.ParseDuration(*timeout)
-	if err != nil {
-		return nil, err
+	if *farmHealth && len(health) > 0 {
+		collectFarmHealth(ch, health)
 	}
-	return &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			TLSClientConfig: &tls.Config{
-				Certificates:       []tls.Certificate{c},
-				InsecureSkipVerify: true,
-			},
-		},
-		Timeout: to,
-	}, nil
 }
 
-func queryAPI(client *http.Client, base, endpoint, query string, result interface{}) error {
-	if query == "" {
-		query = `{"":""}`
-	}
-	b := strings.NewReader(query)
-	r, err := client.Post(base+"/"+endpoint, "application/json", b)
-	if err != nil {
-		return fmt.Errorf("error calling %s: %w", endpoint, err)
-	}
-	//t := io.TeeReader(r.Body, os.Stdout)
-	t := io.TeeReader(r.Body, ioutil.Discard)
-	if err := json.NewDecoder(t).Decode(result); err != nil {
-		if err != nil {
-			return fmt.Errorf("error decoding %s response: %w", endpoint, err)
+// healthMinAckRatio is the lowest ratio of pool points acknowledged to found
+// that counts as healthy for -farm_health.
+const healthMinAckRatio = 0.9
+
+// collectFarmHealth reports the share of healthy signals, each weighted
+// equally.
+func collectFarmHealth(ch chan<- prometheus.Metric, signals []bool) {
+	var ok int
+	for _, s := range signals {
+		if s {
+			ok++
 		}
 	}
-	return nil
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_farm_health",
+			"Share of the farm's health signals that are healthy, 0 to 1.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(ok)/float64(len(signals)),
+	)
 }
 
-type ChiaCollector struct {
-	client       *http.Client
-	full_nodeURL string
-	walletURL    string
-	farmerURL    string
-	harvesterURL string
+// harvestersConnected reports whether the farmer lists any harvesters and all
+// of them are connected.
+func (cc *ChiaCollector) harvestersConnected(hs *Harvesters) bool {
+	for _, h := range hs.Harvesters {
+		if !cc.harvesterConnected(h) {
+			return false
+		}
+	}
+	return len(hs.Harvesters) > 0
 }
 
-// Describe is implemented with DescribeByCollect.
-func (cc ChiaCollector) Describe(ch chan<- *prometheus.Desc) {
-	prometheus.DescribeByCollect(cc, ch)
+// harvesterConnected reports whether the farmer received a plot sync from the
+// harvester within -harvester_stale_after. Harvesters are assumed connected
+// until their first plot sync completes.
+func (cc *ChiaCollector) harvesterConnected(h Harvester) bool {
+	if h.LastSyncTime == nil {
+		return true
+	}
+	last := time.Unix(0, int64(*h.LastSyncTime*1e9))
+	return time.Since(last) <= cc.harvesterStaleAfter
 }
 
-// Collect queries Chia and returns metrics on ch.
-func (cc ChiaCollector) Collect(ch chan<- prometheus.Metric) {
-        // Any endpoint could be set to "disabled" to indicate it's disabled
-        if cc.full_nodeURL != "disabled" {
-	        cc.collectConnections(ch)
-	        cc.collectBlockchainState(ch)
-        }
-        if cc.walletURL != "disabled" {
-	        cc.collectWallets(ch)
-        }
-        if cc.farmerURL != "disabled" {
-	        cc.collectPoolState(ch)
-        }
-        if cc.harvesterURL != "disabled" {
-	        cc.collectPlots(ch)
-        }
+// plotsRemovedTotal returns the number of plots removed so far.
+func (cc *ChiaCollector) plotsRemovedTotal() float64 {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.plotsRemoved
 }
 
-func (cc ChiaCollector) collectConnections(ch chan<- prometheus.Metric) {
+// collectConnections returns the number of connected peers, or -1 if the
+// query failed.
+func (cc *ChiaCollector) collectConnections(ch chan<- prometheus.Metric) int {
 	var conns Connections
-	if err := queryAPI(cc.client, cc.full_nodeURL, "get_connections", "", &conns); err != nil {
+	start := time.Now()
+	if err := cc.query(cc.full_nodeURL, "get_connections", "", &conns); err != nil {
 		log.Print(err)
-		return
+		return -1
 	}
+	// The node does not report round-trip times to its peers, so the time
+	// taken by the RPC itself is exposed as a rough proxy.
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_peers_rpc_latency_seconds",
+			"Time taken by the get_connections RPC, measured by the exporter, in seconds.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		time.Since(start).Seconds(),
+	)
 	peers := make([]int, NumNodeTypes)
 	for _, p := range conns.Connections {
+		if p.Type <= NodeTypeNone || int(p.Type) > NumNodeTypes {
+			debugf("ignoring peer %s of unknown type %d", p.NodeId, p.Type)
+			continue
+		}
 		peers[p.Type-1]++
 	}
 	desc := prometheus.NewDesc(
 		"chia_peers_count",
 		"Number of peers currently connected.",
-		[]string{"type"}, nil,
+		[]string{"type", "node_type"}, nil,
 	)
-	for nt, cnt := range peers {
+	for i, cnt := range peers {
+		nt := NodeType(i + 1)
 		ch <- prometheus.MustNewConstMetric(
 			desc,
 			prometheus.GaugeValue,
 			float64(cnt),
-			strconv.Itoa(nt+1),
+			strconv.Itoa(int(nt)), nt.String(),
+		)
+	}
+	// The RPC does not say which side opened a connection. A connection to
+	// the peer's server port was opened by us, anything else by the peer.
+	var inbound, outbound int
+	for _, p := range conns.Connections {
+		if p.PeerPort == p.PeerServerPort {
+			outbound++
+		} else {
+			inbound++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_peers_inbound",
+			"Number of connected peers that connected to us.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(inbound),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_peers_outbound",
+			"Number of connected peers that we connected to.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(outbound),
+	)
+	// Without an introducer the node can't discover new peers
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_peers_introducer",
+			"Number of connected peers that are introducers.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(peers[NodeTypeIntroducer-1]),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_peers_total",
+			"Number of peers currently connected, of all types.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(len(conns.Connections)),
+	)
+	return len(conns.Connections)
+}
+
+var walletPeersDesc = prometheus.NewDesc(
+	"chia_wallet_peers",
+	"Number of peers the wallet is currently connected to.",
+	[]string{"type", "node_type"}, nil,
+)
+
+// collectWalletConnections reports the wallet's own peers by node type. A
+// wallet without full node peers can't sync, whatever the full node's peers.
+func (cc *ChiaCollector) collectWalletConnections(ch chan<- prometheus.Metric) {
+	var conns Connections
+	if err := cc.query(cc.walletURL, "get_connections", "", &conns); err != nil {
+		log.Print(err)
+		return
+	}
+	peers := make([]int, NumNodeTypes)
+	for _, p := range conns.Connections {
+		if p.Type <= NodeTypeNone || int(p.Type) > NumNodeTypes {
+			debugf("ignoring wallet peer %s of unknown type %d", p.NodeId, p.Type)
+			continue
+		}
+		peers[p.Type-1]++
+	}
+	for i, cnt := range peers {
+		nt := NodeType(i + 1)
+		ch <- prometheus.MustNewConstMetric(
+			walletPeersDesc,
+			prometheus.GaugeValue,
+			float64(cnt),
+			strconv.Itoa(int(nt)), nt.String(),
 		)
 	}
 }
 
-func (cc ChiaCollector) collectBlockchainState(ch chan<- prometheus.Metric) {
-	var bs BlockchainState
-	if err := queryAPI(cc.client, cc.full_nodeURL, "get_blockchain_state", "", &bs); err != nil {
+// collectNetworkInfo reports which network the full node is on.
+func (cc *ChiaCollector) collectNetworkInfo(ch chan<- prometheus.Metric) {
+	var ni NetworkInfo
+	if err := cc.query(cc.full_nodeURL, "get_network_info", "", &ni); err != nil {
 		log.Print(err)
 		return
 	}
+	cc.mu.Lock()
+	changed := ni.NetworkName != cc.nodeNetwork
+	cc.nodeNetwork = ni.NetworkName
+	cc.mu.Unlock()
+	if changed && ni.NetworkName != *network {
+		log.Printf("Full node is on %s, but -network is %s: the plot filter, block reward and epoch metrics follow %s", ni.NetworkName, *network, *network)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_network_info",
+			"Network of the full node, always 1.",
+			[]string{"network_name", "network_prefix"}, nil,
+		),
+		prometheus.GaugeValue,
+		1,
+		ni.NetworkName, ni.NetworkPrefix,
+	)
+}
+
+var blocksValidatedDesc = prometheus.NewDesc(
+	"chia_full_node_blocks_validated_total",
+	"Number of blocks the peak advanced by between scrapes, as validated by the full node.",
+	nil, nil,
+)
+
+// collectBlocksValidated counts the blocks validated by the full node. The
+// node reports no such count, so it is derived from how far the peak height
+// moved since the previous scrape. A peak moving back, e.g. on a reorg, counts
+// nothing.
+func (cc *ChiaCollector) collectBlocksValidated(ch chan<- prometheus.Metric, height int) {
+	cc.mu.Lock()
+	if cc.peakSeen && height > cc.peakHeight {
+		cc.blocksValidated += float64(height - cc.peakHeight)
+	}
+	cc.peakHeight = height
+	cc.peakSeen = true
+	validated := cc.blocksValidated
+	cc.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(
+		blocksValidatedDesc,
+		prometheus.CounterValue,
+		validated,
+	)
+}
+
+// collectBlockchainState returns the decoded state so that derived metrics can
+// be computed from it, or nil if the query failed.
+func (cc *ChiaCollector) collectBlockchainState(ch chan<- prometheus.Metric) *BlockchainState {
+	var bs BlockchainState
+	if err := cc.query(cc.full_nodeURL, "get_blockchain_state", "", &bs); err != nil {
+		log.Print(err)
+		return nil
+	}
 	sync := 0.0
 	if bs.BlockchainState.Sync.SyncMode {
 		sync = 1.0
@@ -214,25 +2065,42 @@ func (cc ChiaCollector) collectBlockchainState(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_blockchain_sync_status",
-			"Sync status, 0=not synced, 1=syncing, 2=synced",
+			"Sync status of the full node, 0=not synced, 1=syncing, 2=synced.",
 			nil, nil,
 		),
 		prometheus.GaugeValue,
 		sync,
 	)
+	// Only meaningful during a long sync, for estimating when it finishes
+	if bs.BlockchainState.Sync.SyncMode {
+		remaining := bs.BlockchainState.Sync.SyncTipHeight - bs.BlockchainState.Sync.SyncProgressHeight
+		if remaining < 0 {
+			remaining = 0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				"chia_blockchain_sync_remaining_blocks",
+				"Number of blocks left to sync to the sync tip, only while syncing.",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			float64(remaining),
+		)
+	}
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_blockchain_height",
-			"Current height",
+			"Height of the peak block.",
 			nil, nil,
 		),
 		prometheus.GaugeValue,
 		float64(bs.BlockchainState.Peak.Height),
 	)
+	cc.collectBlocksValidated(ch, bs.BlockchainState.Peak.Height)
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_blockchain_difficulty",
-			"Current difficulty",
+			"Current difficulty of the blockchain.",
 			nil, nil,
 		),
 		prometheus.GaugeValue,
@@ -241,7 +2109,7 @@ func (cc ChiaCollector) collectBlockchainState(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_blockchain_space_bytes",
-			"Estimated current netspace",
+			"Estimated current netspace, in bytes.",
 			nil, nil,
 		),
 		prometheus.GaugeValue,
@@ -250,80 +2118,343 @@ func (cc ChiaCollector) collectBlockchainState(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_blockchain_total_iters",
-			"Current total iterations",
+			"Total iterations of the peak block.",
 			nil, nil,
 		),
 		prometheus.GaugeValue,
 		float64(bs.BlockchainState.Peak.TotalIters),
 	)
+	// Also as a counter, so that increase() and rate() treat it as the
+	// monotonic value it is.
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_blockchain_iters_total",
+			"Total iterations of the peak block, as a counter.",
+			nil, nil,
+		),
+		prometheus.CounterValue,
+		float64(bs.BlockchainState.Peak.TotalIters),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_blockchain_signage_point_index",
+			"Signage point index of the peak block within its sub-slot.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(bs.BlockchainState.Peak.SignagePointIndex),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_plot_filter_bits",
+			"Number of zero bits required to pass the plot filter at the current height.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(cc.consensus.plotFilterAtHeight(uint32(bs.BlockchainState.Peak.Height))),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_block_reward_xch",
+			"Total block reward at the current height, in XCH.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		cc.consensus.blockRewardAtHeight(uint32(bs.BlockchainState.Peak.Height)),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_blockchain_seconds_to_next_epoch",
+			"Estimated time until the next epoch boundary, where difficulty is adjusted, at the target block time, in seconds.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		cc.consensus.secondsToNextEpoch(uint32(bs.BlockchainState.Peak.Height)),
+	)
+	// Only transaction blocks carry a timestamp, null otherwise
+	if ts, ok := bs.BlockchainState.Peak.Timestamp.(float64); ok {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				"chia_full_node_clock_skew_seconds",
+				"Local time minus the timestamp of the peak transaction block, in seconds.",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			float64(time.Now().UnixNano())/1e9-ts,
+		)
+	}
+	return &bs
 }
 
-func (cc ChiaCollector) collectWallets(ch chan<- prometheus.Metric) {
+// collectWallets collects metrics for each wallet. bs is the full node's
+// blockchain state from the same scrape, or nil if it is not available. It
+// returns whether all wallets are synced.
+func (cc *ChiaCollector) collectWallets(ch chan<- prometheus.Metric, bs *BlockchainState) bool {
 	var ws Wallets
-	if err := queryAPI(cc.client, cc.walletURL, "get_wallets", "", &ws); err != nil {
+	if err := cc.query(cc.walletURL, "get_wallets", "", &ws); err != nil {
 		log.Print(err)
-		return
+		cc.collectWalletUp(ch, "query failed")
+		return false
+	}
+	if !ws.Success {
+		reason := ws.Error
+		if reason == "" {
+			reason = "unknown error"
+		}
+		cc.collectWalletUp(ch, reason)
+		return false
+	}
+	cc.collectWalletUp(ch, "")
+	var cats map[string]catName
+	for _, w := range ws.Wallets {
+		if isCAT(w) {
+			cats = cc.getCATList()
+			break
+		}
+	}
+	// The keys are those of the node, whichever wallet they are for
+	fingerprint, keys := cc.getWalletPublicKey()
+	if keys >= 0 {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				"chia_node_public_keys",
+				"Number of public keys on the node.",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			float64(keys),
+		)
 	}
+	var farmed *FarmedAmount
+	synced := true
 	for _, w := range ws.Wallets {
 		w.StringID = strconv.Itoa(w.ID)
-		w.PublicKey = cc.getWalletPublicKey(w)
+		w.TypeName = walletTypeName(w.Type)
+		w.PublicKey = fingerprint
+		if isCAT(w) {
+			w.AssetName, w.AssetSymbol = cc.resolveCAT(w, cats)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			walletInfoDesc,
+			prometheus.GaugeValue,
+			1,
+			w.StringID, w.PublicKey, w.TypeName,
+		)
 		cc.collectWalletBalance(ch, w)
-		cc.collectWalletSync(ch, w)
-		cc.collectFarmedAmount(ch, w)
+		if !cc.collectWalletSync(ch, w, bs) {
+			synced = false
+		}
+		if f := cc.collectFarmedAmount(ch, w, bs); f != nil {
+			farmed = f
+		}
+		cc.collectLastTransaction(ch, w)
+		cc.collectPendingTransactions(ch, w)
+		if *usedAddresses && w.Type == WalletTypeStandard {
+			cc.collectUsedAddresses(ch, w)
+		}
+		if w.Type == WalletTypePool {
+			cc.collectPoolWalletStatus(ch, w)
+		}
+	}
+	walletsOfType := make(map[int]int)
+	for _, w := range ws.Wallets {
+		walletsOfType[w.Type]++
+	}
+	for _, t := range []int{WalletTypeCAT, WalletTypeDID, WalletTypeNFT} {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				"chia_"+walletTypeName(t)+"_wallets_total",
+				"Number of wallets of type "+walletTypeName(t)+".",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			float64(walletsOfType[t]),
+		)
 	}
+	// The farmed amounts are those of the node, whichever wallet they were
+	// asked for, so the total and fees are also reported once
+	if farmed != nil {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				"chia_farmed_amount_xch",
+				"Total amount farmed by the node, in XCH.",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			float64(farmed.FarmedAmount)/MojoPerXCH,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				"chia_farmed_xch_total",
+				"Total amount farmed by the node, in XCH, as a counter.",
+				nil, nil,
+			),
+			prometheus.CounterValue,
+			float64(farmed.FarmedAmount)/MojoPerXCH,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				"chia_farmer_fees_collected_mojo",
+				"Transaction fees collected in farmed blocks, in mojo.",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			float64(farmed.FeeAmount),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				"chia_farmer_fees_collected_xch",
+				"Transaction fees collected in farmed blocks, in XCH.",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			float64(farmed.FeeAmount)/MojoPerXCH,
+		)
+	}
+	return synced
+}
+
+var walletInfoDesc = prometheus.NewDesc(
+	"chia_wallet_info",
+	"Wallet identity, always 1.",
+	[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
+)
+
+var walletUpDesc = prometheus.NewDesc(
+	"chia_wallet_up",
+	"Whether the wallet returned its wallets, 1=up, 0=down with the reason.",
+	[]string{"reason"}, nil,
+)
+
+// collectWalletUp reports the wallet as up if reason is empty, otherwise as
+// down. A reason is logged only when it changes, rather than every scrape.
+func (cc *ChiaCollector) collectWalletUp(ch chan<- prometheus.Metric, reason string) {
+	cc.mu.Lock()
+	if reason != "" && reason != cc.walletDownReason {
+		log.Printf("wallet is down: %s", reason)
+	}
+	cc.walletDownReason = reason
+	cc.mu.Unlock()
+	up := 1.0
+	if reason != "" {
+		up = 0.0
+	}
+	ch <- prometheus.MustNewConstMetric(
+		walletUpDesc,
+		prometheus.GaugeValue,
+		up,
+		reason,
+	)
 }
 
-// getWalletPublicKey returns the fingerprint of first public key associated
-// with the wallet.
-func (cc ChiaCollector) getWalletPublicKey(w Wallet) string {
+// noFingerprint is the wallet_fingerprint label value used when a wallet's
+// fingerprint is not available.
+const noFingerprint = "none"
+
+// getWalletPublicKey returns the fingerprint of the first public key on the
+// node, which the wallets belong to, or noFingerprint if there is none, and
+// the number of public keys. The number is -1 if the query failed.
+func (cc *ChiaCollector) getWalletPublicKey() (string, int) {
 	var wpks WalletPublicKeys
-	q := fmt.Sprintf(`{"wallet_id":%d}`, w.ID)
-	if err := queryAPI(cc.client, cc.walletURL, "get_public_keys", q, &wpks); err != nil {
+	if err := cc.query(cc.walletURL, "get_public_keys", "", &wpks); err != nil {
 		log.Print(err)
-		return ""
+		return noFingerprint, -1
 	}
-	if len(wpks.PublicKeyFingerprints) < 1 {
-		log.Print("no public key")
-		return ""
+	n := len(wpks.PublicKeyFingerprints)
+	if n < 1 {
+		debugf("no public keys on the node")
+		return noFingerprint, 0
 	}
-	if len(wpks.PublicKeyFingerprints) > 1 {
+	if n > 1 {
 		log.Print("more than one public key; returning first")
 	}
-	return strconv.Itoa(wpks.PublicKeyFingerprints[0])
+	return strconv.Itoa(wpks.PublicKeyFingerprints[0]), n
+}
+
+// catName is the name and symbol of a CAT.
+type catName struct {
+	name, symbol string
+}
+
+// isCAT reports whether the wallet holds a CAT.
+func isCAT(w Wallet) bool {
+	return w.Type == WalletTypeCAT || w.Type == WalletTypeCRCAT
+}
+
+// getCATList returns the well-known CATs of the wallet by asset id, fetched
+// once per scrape for resolving the CAT wallets. It is empty on wallets
+// without the list.
+func (cc *ChiaCollector) getCATList() map[string]catName {
+	cats := make(map[string]catName)
+	var cl CATList
+	if err := cc.query(cc.walletURL, "get_cat_list", "", &cl); err != nil {
+		log.Print(err)
+		return cats
+	}
+	for _, c := range cl.CATList {
+		cats[c.AssetID] = catName{c.Name, c.Symbol}
+	}
+	return cats
+}
+
+// resolveCAT returns the name and symbol of the CAT held by the wallet, from
+// the well-known CATs in cats, otherwise the name the wallet knows it by
+// without a symbol. Both are empty if the CAT can't be resolved.
+func (cc *ChiaCollector) resolveCAT(w Wallet, cats map[string]catName) (string, string) {
+	var id CATAssetID
+	q := fmt.Sprintf(`{"wallet_id":%d}`, w.ID)
+	if err := cc.query(cc.walletURL, "cat_get_asset_id", q, &id); err != nil {
+		log.Print(err)
+		return "", ""
+	}
+	if !id.Success || id.AssetID == "" {
+		debugf("no asset id for CAT wallet %d", w.ID)
+		return "", ""
+	}
+	if c, ok := cats[id.AssetID]; ok {
+		return c.name, c.symbol
+	}
+	var name CATAssetName
+	q = fmt.Sprintf(`{"asset_id":%q}`, id.AssetID)
+	if err := cc.query(cc.walletURL, "cat_asset_id_to_name", q, &name); err != nil {
+		log.Print(err)
+		return "", ""
+	}
+	return name.Name, ""
 }
 
 var (
 	confirmedBalanceDesc = prometheus.NewDesc(
 		"chia_wallet_confirmed_balance_mojo",
-		"Confirmed wallet balance.",
-		[]string{"wallet_id", "wallet_fingerprint"}, nil,
+		"Confirmed wallet balance, in mojo.",
+		[]string{"wallet_id", "wallet_fingerprint", "wallet_type", "asset_name", "asset_symbol"}, nil,
 	)
 	unconfirmedBalanceDesc = prometheus.NewDesc(
 		"chia_wallet_unconfirmed_balance_mojo",
-		"Unconfirmed wallet balance.",
-		[]string{"wallet_id", "wallet_fingerprint"}, nil,
+		"Unconfirmed wallet balance, including pending transactions, in mojo.",
+		[]string{"wallet_id", "wallet_fingerprint", "wallet_type", "asset_name", "asset_symbol"}, nil,
 	)
 	spendableBalanceDesc = prometheus.NewDesc(
 		"chia_wallet_spendable_balance_mojo",
-		"Spendable wallet balance.",
-		[]string{"wallet_id", "wallet_fingerprint"}, nil,
+		"Spendable wallet balance, in mojo.",
+		[]string{"wallet_id", "wallet_fingerprint", "wallet_type", "asset_name", "asset_symbol"}, nil,
 	)
 	maxSendDesc = prometheus.NewDesc(
 		"chia_wallet_max_send_mojo",
-		"Maximum sendable amount.",
-		[]string{"wallet_id", "wallet_fingerprint"}, nil,
+		"Maximum amount the wallet can send in one transaction, in mojo.",
+		[]string{"wallet_id", "wallet_fingerprint", "wallet_type", "asset_name", "asset_symbol"}, nil,
 	)
 	pendingChangeDesc = prometheus.NewDesc(
 		"chia_wallet_pending_change_mojo",
-		"Pending change amount.",
-		[]string{"wallet_id", "wallet_fingerprint"}, nil,
+		"Change of pending transactions the wallet is waiting for, in mojo.",
+		[]string{"wallet_id", "wallet_fingerprint", "wallet_type", "asset_name", "asset_symbol"}, nil,
 	)
 )
 
-func (cc ChiaCollector) collectWalletBalance(ch chan<- prometheus.Metric, w Wallet) {
+func (cc *ChiaCollector) collectWalletBalance(ch chan<- prometheus.Metric, w Wallet) {
 	var wb WalletBalance
 	q := fmt.Sprintf(`{"wallet_id":%d}`, w.ID)
-	if err := queryAPI(cc.client, cc.walletURL, "get_wallet_balance", q, &wb); err != nil {
+	if err := cc.query(cc.walletURL, "get_wallet_balance", q, &wb); err != nil {
 		log.Print(err)
 		return
 	}
@@ -331,53 +2462,64 @@ func (cc ChiaCollector) collectWalletBalance(ch chan<- prometheus.Metric, w Wall
 		confirmedBalanceDesc,
 		prometheus.GaugeValue,
 		float64(wb.WalletBalance.ConfirmedBalance),
-		w.StringID, w.PublicKey,
+		w.StringID, w.PublicKey, w.TypeName, w.AssetName, w.AssetSymbol,
 	)
 	ch <- prometheus.MustNewConstMetric(
 		unconfirmedBalanceDesc,
 		prometheus.GaugeValue,
 		float64(wb.WalletBalance.UnconfirmedBalance),
-		w.StringID, w.PublicKey,
+		w.StringID, w.PublicKey, w.TypeName, w.AssetName, w.AssetSymbol,
 	)
 	ch <- prometheus.MustNewConstMetric(
 		spendableBalanceDesc,
 		prometheus.GaugeValue,
 		float64(wb.WalletBalance.SpendableBalance),
-		w.StringID, w.PublicKey,
+		w.StringID, w.PublicKey, w.TypeName, w.AssetName, w.AssetSymbol,
 	)
 	ch <- prometheus.MustNewConstMetric(
 		maxSendDesc,
 		prometheus.GaugeValue,
 		float64(wb.WalletBalance.MaxSendAmount),
-		w.StringID, w.PublicKey,
+		w.StringID, w.PublicKey, w.TypeName, w.AssetName, w.AssetSymbol,
 	)
 	ch <- prometheus.MustNewConstMetric(
 		pendingChangeDesc,
 		prometheus.GaugeValue,
 		float64(wb.WalletBalance.PendingChange),
-		w.StringID, w.PublicKey,
+		w.StringID, w.PublicKey, w.TypeName, w.AssetName, w.AssetSymbol,
 	)
 }
 
 var (
 	walletSyncStatusDesc = prometheus.NewDesc(
 		"chia_wallet_sync_status",
-		"Sync status, 0=not synced, 1=syncing, 2=synced",
-		[]string{"wallet_id", "wallet_fingerprint"}, nil,
+		"Sync status of the wallet, 0=not synced, 1=syncing, 2=synced.",
+		[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
+	)
+	walletGenesisInitializedDesc = prometheus.NewDesc(
+		"chia_wallet_genesis_initialized",
+		"Whether the wallet has completed its initial setup, 1=initialized, 0=not.",
+		[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
 	)
 	walletHeightDesc = prometheus.NewDesc(
 		"chia_wallet_height",
-		"Wallet synced height.",
-		[]string{"wallet_id", "wallet_fingerprint"}, nil,
+		"Height the wallet is synced to.",
+		[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
+	)
+	walletHeightLagDesc = prometheus.NewDesc(
+		"chia_wallet_height_lag",
+		"Number of blocks the wallet is behind the full node peak.",
+		[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
 	)
 )
 
-func (cc ChiaCollector) collectWalletSync(ch chan<- prometheus.Metric, w Wallet) {
+// collectWalletSync returns whether the wallet is synced.
+func (cc *ChiaCollector) collectWalletSync(ch chan<- prometheus.Metric, w Wallet, bs *BlockchainState) bool {
 	var wss WalletSyncStatus
 	q := fmt.Sprintf(`{"wallet_id":%d}`, w.ID)
-	if err := queryAPI(cc.client, cc.walletURL, "get_sync_status", q, &wss); err != nil {
+	if err := cc.query(cc.walletURL, "get_sync_status", q, &wss); err != nil {
 		log.Print(err)
-		return
+		return false
 	}
 	sync := 0.0
 	if wss.Syncing {
@@ -389,33 +2531,63 @@ func (cc ChiaCollector) collectWalletSync(ch chan<- prometheus.Metric, w Wallet)
 		walletSyncStatusDesc,
 		prometheus.GaugeValue,
 		sync,
-		w.StringID, w.PublicKey,
+		w.StringID, w.PublicKey, w.TypeName,
+	)
+	var initialized float64
+	if wss.GenesisInitialized {
+		initialized = 1
+	}
+	ch <- prometheus.MustNewConstMetric(
+		walletGenesisInitializedDesc,
+		prometheus.GaugeValue,
+		initialized,
+		w.StringID, w.PublicKey, w.TypeName,
 	)
 
 	var whi WalletHeightInfo
-	if err := queryAPI(cc.client, cc.walletURL, "get_height_info", q, &whi); err != nil {
+	if err := cc.query(cc.walletURL, "get_height_info", q, &whi); err != nil {
 		log.Print(err)
-		return
+		return wss.Synced
 	}
 	ch <- prometheus.MustNewConstMetric(
 		walletHeightDesc,
 		prometheus.GaugeValue,
-		float64(whi.Height),
-		w.StringID, w.PublicKey,
+		float64(whi.Height),
+		w.StringID, w.PublicKey, w.TypeName,
+	)
+	if bs == nil {
+		return wss.Synced
+	}
+	ch <- prometheus.MustNewConstMetric(
+		walletHeightLagDesc,
+		prometheus.GaugeValue,
+		float64(int64(bs.BlockchainState.Peak.Height)-whi.Height),
+		w.StringID, w.PublicKey, w.TypeName,
 	)
+	return wss.Synced
 }
 
-func (cc ChiaCollector) collectPoolState(ch chan<- prometheus.Metric) {
+var poolDifficultyChangesDesc = prometheus.NewDesc(
+	"chia_pool_difficulty_changes_total",
+	"Number of times the difficulty on the pool changed between scrapes.",
+	[]string{"launcher_id", "pool_url"}, nil,
+)
+
+// collectPoolState returns the lowest ratio of points acknowledged to points
+// found over the last 24h among the pools, 1 if no points were found, or -1
+// if the query failed.
+func (cc *ChiaCollector) collectPoolState(ch chan<- prometheus.Metric) float64 {
 	var pools PoolState
-	if err := queryAPI(cc.client, cc.farmerURL, "get_pool_state", "", &pools); err != nil {
+	if err := cc.query(cc.farmerURL, "get_pool_state", "", &pools); err != nil {
 		log.Print(err)
-		return
+		return -1
 	}
+	lowest := 1.0
 	for _, p := range pools.PoolState {
 		ch <- prometheus.MustNewConstMetric(
 			prometheus.NewDesc(
 				"chia_pool_current_difficulty",
-				"Current difficulty on pool.",
+				"Current difficulty of partials on the pool.",
 				[]string{"launcher_id", "pool_url"}, nil,
 			),
 			prometheus.GaugeValue,
@@ -426,7 +2598,7 @@ func (cc ChiaCollector) collectPoolState(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(
 			prometheus.NewDesc(
 				"chia_pool_current_points",
-				"Current points on pool.",
+				"Current points on the pool, reset by the pool on payout.",
 				[]string{"launcher_id", "pool_url"}, nil,
 			),
 			prometheus.GaugeValue,
@@ -437,7 +2609,7 @@ func (cc ChiaCollector) collectPoolState(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(
 			prometheus.NewDesc(
 				"chia_pool_points_acknowledged_24h",
-				"Points acknowledged last 24h on pool.",
+				"Points acknowledged by the pool in the last 24h.",
 				[]string{"launcher_id", "pool_url"}, nil,
 			),
 			prometheus.GaugeValue,
@@ -448,7 +2620,7 @@ func (cc ChiaCollector) collectPoolState(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(
 			prometheus.NewDesc(
 				"chia_pool_points_found_24h",
-				"Points found last 24h on pool.",
+				"Points found for the pool in the last 24h.",
 				[]string{"launcher_id", "pool_url"}, nil,
 			),
 			prometheus.GaugeValue,
@@ -456,19 +2628,313 @@ func (cc ChiaCollector) collectPoolState(ch chan<- prometheus.Metric) {
 			p.PoolConfig.LauncherId,
 			p.PoolConfig.PoolURL,
 		)
+		// Each entry is a timestamp and the points of a partial
+		var acked, found float64
+		for _, e := range p.PointsAcknowledged24h {
+			acked += e[1]
+		}
+		var last float64
+		for _, e := range p.PointsFound24h {
+			found += e[1]
+			last = math.Max(last, e[0])
+		}
+		if last > 0 {
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(
+					"chia_pool_last_partial_timestamp_seconds",
+					"Time of the latest partial found for the pool within the last 24h, in seconds since the epoch.",
+					[]string{"launcher_id", "pool_url"}, nil,
+				),
+				prometheus.GaugeValue,
+				last,
+				p.PoolConfig.LauncherId,
+				p.PoolConfig.PoolURL,
+			)
+		}
+		if found > 0 {
+			lowest = math.Min(lowest, acked/found)
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(
+					"chia_pool_points_ack_ratio_24h",
+					"Ratio of points acknowledged to points found for the pool in the last 24h.",
+					[]string{"launcher_id", "pool_url"}, nil,
+				),
+				prometheus.GaugeValue,
+				acked/found,
+				p.PoolConfig.LauncherId,
+				p.PoolConfig.PoolURL,
+			)
+		}
+		cc.mu.Lock()
+		prev, seen := cc.poolDifficulty[p.PoolConfig.LauncherId]
+		if seen && prev != p.CurrentDificulty {
+			cc.poolDifficultyChanges[p.PoolConfig.LauncherId]++
+		}
+		cc.poolDifficulty[p.PoolConfig.LauncherId] = p.CurrentDificulty
+		changes := cc.poolDifficultyChanges[p.PoolConfig.LauncherId]
+		cc.mu.Unlock()
+		ch <- prometheus.MustNewConstMetric(
+			poolDifficultyChangesDesc,
+			prometheus.CounterValue,
+			changes,
+			p.PoolConfig.LauncherId,
+			p.PoolConfig.PoolURL,
+		)
+		cc.collectPoolPoints(ch, p.PoolConfig.LauncherId, p.PoolConfig.PoolURL, p.CurrentPoints)
+	}
+	return lowest
+}
+
+var (
+	poolPointsRateDesc = prometheus.NewDesc(
+		"chia_pool_points_rate_per_hour",
+		"Rate of points earned on pool since the previous scrape, per hour.",
+		[]string{"launcher_id", "pool_url"}, nil,
+	)
+	poolPointsEarnedDesc = prometheus.NewDesc(
+		"chia_pool_points_earned_total",
+		"Number of points earned on the pool since the exporter started, across payouts.",
+		[]string{"launcher_id", "pool_url"}, nil,
+	)
+)
+
+// poolPoints is the current points on a pool at the time of a scrape.
+type poolPoints struct {
+	points int64
+	at     time.Time
+}
+
+// collectPoolPoints reports the points earned since the previous scrape, as a
+// rate and accumulated into a counter. Pools reset the points on payout, so
+// points below those of the previous scrape were all earned since the reset.
+// No rate is reported on the first scrape or after a reset.
+func (cc *ChiaCollector) collectPoolPoints(ch chan<- prometheus.Metric, launcher, poolURL string, points int64) {
+	now := time.Now()
+	cc.mu.Lock()
+	prev, seen := cc.poolPoints[launcher]
+	cc.poolPoints[launcher] = poolPoints{points, now}
+	if seen {
+		if points < prev.points {
+			cc.poolPointsEarned[launcher] += float64(points)
+		} else {
+			cc.poolPointsEarned[launcher] += float64(points - prev.points)
+		}
+	}
+	earned := cc.poolPointsEarned[launcher]
+	cc.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(
+		poolPointsEarnedDesc,
+		prometheus.CounterValue,
+		earned,
+		launcher, poolURL,
+	)
+	elapsed := now.Sub(prev.at).Hours()
+	if !seen || points < prev.points || elapsed <= 0 {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(
+		poolPointsRateDesc,
+		prometheus.GaugeValue,
+		float64(points-prev.points)/elapsed,
+		launcher, poolURL,
+	)
+}
+
+var (
+	farmerHarvesterPlotsDesc = prometheus.NewDesc(
+		"chia_farmer_harvester_plots",
+		"Number of plots reported to the farmer by a harvester.",
+		[]string{"node_id", "host"}, nil,
+	)
+	farmerHarvesterPlotSizeDesc = prometheus.NewDesc(
+		"chia_farmer_harvester_plot_size_bytes",
+		"Total size of the plots reported to the farmer by a harvester, in bytes.",
+		[]string{"node_id", "host"}, nil,
+	)
+	farmerHarvesterAvgPlotKDesc = prometheus.NewDesc(
+		"chia_farmer_harvester_avg_plot_k",
+		"Average k size of the plots reported to the farmer by a harvester, weighted by plot size.",
+		[]string{"node_id", "host"}, nil,
+	)
+	farmerAvgPlotKDesc = prometheus.NewDesc(
+		"chia_farmer_avg_plot_k",
+		"Average k size of the plots of all harvesters, weighted by plot size.",
+		nil, nil,
+	)
+	farmerPlotsByFarmingTypeDesc = prometheus.NewDesc(
+		"chia_farmer_plots_by_farming_type",
+		"Number of plots of all harvesters by farming type, pooled (plot NFT), og (pool public key) or unknown.",
+		[]string{"type"}, nil,
+	)
+	farmerMaxPlotKDesc = prometheus.NewDesc(
+		"chia_farmer_max_plot_k",
+		"Largest k size of the plots of all harvesters.",
+		nil, nil,
+	)
+	farmerMinPlotKDesc = prometheus.NewDesc(
+		"chia_farmer_min_plot_k",
+		"Smallest k size of the plots of all harvesters.",
+		nil, nil,
+	)
+	harvesterPlotsLoadingDesc = prometheus.NewDesc(
+		"chia_harvester_plots_loading",
+		"Number of plot files a harvester has yet to process in its current plot refresh.",
+		[]string{"node_id", "host"}, nil,
+	)
+	farmerHarvesterConnectedDesc = prometheus.NewDesc(
+		"chia_farmer_harvester_connected",
+		"Whether a harvester listed by the farmer has completed a plot sync within -harvester_stale_after.",
+		[]string{"node_id", "host"}, nil,
+	)
+	farmerTotalPlotsDesc = prometheus.NewDesc(
+		"chia_farmer_total_plots",
+		"Number of plots reported to the farmer across all harvesters.",
+		nil, nil,
+	)
+	farmerTotalPlotSizeDesc = prometheus.NewDesc(
+		"chia_farmer_total_plot_size_bytes",
+		"Total size of plots reported to the farmer across all harvesters, in bytes.",
+		nil, nil,
+	)
+	farmerEffectivePlotSizeDesc = prometheus.NewDesc(
+		"chia_farmer_effective_plot_size_bytes",
+		"Total effective size of plots reported to the farmer across all harvesters, counting compressed plots at their uncompressed size, in bytes.",
+		nil, nil,
+	)
+)
+
+// collectFarmerHarvesters returns the decoded harvesters so that derived
+// metrics can be computed from them, or nil if the query failed.
+func (cc *ChiaCollector) collectFarmerHarvesters(ch chan<- prometheus.Metric) *Harvesters {
+	var hs Harvesters
+	if err := cc.query(cc.farmerURL, "get_harvesters", "", &hs); err != nil {
+		log.Print(err)
+		return nil
+	}
+	var plots int
+	var size, effective, kSize float64
+	var minK, maxK int64
+	farmingTypes := map[string]int{"pooled": 0, "og": 0, "unknown": 0}
+	for _, h := range hs.Harvesters {
+		ch <- prometheus.MustNewConstMetric(
+			farmerHarvesterPlotsDesc,
+			prometheus.GaugeValue,
+			float64(len(h.Plots)),
+			h.Connection.NodeID, h.Connection.Host,
+		)
+		// Null until the harvester's first plot sync completes
+		if h.LastSyncTime != nil {
+			var connected float64
+			if cc.harvesterConnected(h) {
+				connected = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				farmerHarvesterConnectedDesc,
+				prometheus.GaugeValue,
+				connected,
+				h.Connection.NodeID, h.Connection.Host,
+			)
+		}
+		plots += len(h.Plots)
+		var loading int
+		if h.Syncing != nil {
+			loading = h.Syncing.PlotFilesTotal - h.Syncing.PlotFilesProcessed
+		}
+		ch <- prometheus.MustNewConstMetric(
+			harvesterPlotsLoadingDesc,
+			prometheus.GaugeValue,
+			float64(loading),
+			h.Connection.NodeID, h.Connection.Host,
+		)
+		// k weighted by file size, for the average k
+		var harvesterSize, harvesterKSize float64
+		for _, p := range h.Plots {
+			harvesterSize += float64(p.FileSize)
+			harvesterKSize += float64(p.Size * p.FileSize)
+			effective += effectivePlotSize(p)
+			farmingTypes[farmingType(p)]++
+			if minK == 0 || p.Size < minK {
+				minK = p.Size
+			}
+			if p.Size > maxK {
+				maxK = p.Size
+			}
+		}
+		size += harvesterSize
+		kSize += harvesterKSize
+		ch <- prometheus.MustNewConstMetric(
+			farmerHarvesterPlotSizeDesc,
+			prometheus.GaugeValue,
+			harvesterSize,
+			h.Connection.NodeID, h.Connection.Host,
+		)
+		if harvesterSize > 0 {
+			ch <- prometheus.MustNewConstMetric(
+				farmerHarvesterAvgPlotKDesc,
+				prometheus.GaugeValue,
+				harvesterKSize/harvesterSize,
+				h.Connection.NodeID, h.Connection.Host,
+			)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(
+		farmerTotalPlotsDesc,
+		prometheus.GaugeValue,
+		float64(plots),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		farmerTotalPlotSizeDesc,
+		prometheus.GaugeValue,
+		size,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		farmerEffectivePlotSizeDesc,
+		prometheus.GaugeValue,
+		effective,
+	)
+	if size > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			farmerAvgPlotKDesc,
+			prometheus.GaugeValue,
+			kSize/size,
+		)
+	}
+	for t, n := range farmingTypes {
+		ch <- prometheus.MustNewConstMetric(
+			farmerPlotsByFarmingTypeDesc,
+			prometheus.GaugeValue,
+			float64(n),
+			t,
+		)
+	}
+	// Stray small plots, e.g. k25 test plots, show up in the minimum
+	if plots > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			farmerMaxPlotKDesc,
+			prometheus.GaugeValue,
+			float64(maxK),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			farmerMinPlotKDesc,
+			prometheus.GaugeValue,
+			float64(minK),
+		)
 	}
+	return &hs
 }
 
-func (cc ChiaCollector) collectPlots(ch chan<- prometheus.Metric) {
+// collectPlots returns the decoded plot list so that derived metrics can be
+// computed from it, or nil if the query failed.
+func (cc *ChiaCollector) collectPlots(ch chan<- prometheus.Metric) *PlotFiles {
 	var plots PlotFiles
-	if err := queryAPI(cc.client, cc.harvesterURL, "get_plots", "", &plots); err != nil {
+	if err := cc.query(cc.harvesterURL, "get_plots", "", &plots); err != nil {
 		log.Print(err)
-		return
+		return nil
 	}
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_plots_failed_to_open",
-			"Number of plots files failed to open.",
+			"Number of plot files the harvester failed to open.",
 			nil, nil,
 		),
 		prometheus.GaugeValue,
@@ -477,7 +2943,7 @@ func (cc ChiaCollector) collectPlots(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_plots_not_found",
-			"Number of plots files not found.",
+			"Number of plot files the harvester did not find.",
 			nil, nil,
 		),
 		prometheus.GaugeValue,
@@ -486,69 +2952,488 @@ func (cc ChiaCollector) collectPlots(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_plots",
-			"Number of plots currently using.",
+			"Number of plots the harvester is farming.",
 			nil, nil,
 		),
 		prometheus.GaugeValue,
 		float64(len(plots.Plots)),
 	)
+	cc.collectPlotChanges(ch, len(plots.Plots))
+	return &plots
+}
+
+var (
+	plotsAddedDesc = prometheus.NewDesc(
+		"chia_plots_added_total",
+		"Number of plots added between scrapes.",
+		nil, nil,
+	)
+	plotsRemovedDesc = prometheus.NewDesc(
+		"chia_plots_removed_total",
+		"Number of plots removed between scrapes.",
+		nil, nil,
+	)
+)
+
+// collectPlotChanges accumulates the change in plot count since the previous
+// scrape. The first scrape only establishes the baseline.
+func (cc *ChiaCollector) collectPlotChanges(ch chan<- prometheus.Metric, count int) {
+	cc.mu.Lock()
+	if cc.plotsSeen {
+		if d := count - cc.plotCount; d > 0 {
+			cc.plotsAdded += float64(d)
+		} else {
+			cc.plotsRemoved -= float64(d)
+		}
+	}
+	cc.plotCount = count
+	cc.plotsSeen = true
+	added, removed := cc.plotsAdded, cc.plotsRemoved
+	cc.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(
+		plotsAddedDesc,
+		prometheus.CounterValue,
+		added,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		plotsRemovedDesc,
+		prometheus.CounterValue,
+		removed,
+	)
+}
+
+var (
+	plotDirectoriesDesc = prometheus.NewDesc(
+		"chia_harvester_plot_directories",
+		"Number of plot directories configured on the harvester.",
+		nil, nil,
+	)
+	plotDirectoryInfoDesc = prometheus.NewDesc(
+		"chia_harvester_plot_directory_info",
+		"Plot directory configured on the harvester.",
+		[]string{"path"}, nil,
+	)
+	plotDirectoryFreeDesc = prometheus.NewDesc(
+		"chia_plot_directory_free_bytes",
+		"Free space available in the plot directory, in bytes.",
+		[]string{"path"}, nil,
+	)
+)
+
+// collectPlotDirectories returns the configured plot directories, or nil if
+// the query failed.
+func (cc *ChiaCollector) collectPlotDirectories(ch chan<- prometheus.Metric) []string {
+	var dirs PlotDirectories
+	if err := cc.query(cc.harvesterURL, "get_plot_directories", "", &dirs); err != nil {
+		log.Print(err)
+		return nil
+	}
+	ch <- prometheus.MustNewConstMetric(
+		plotDirectoriesDesc,
+		prometheus.GaugeValue,
+		float64(len(dirs.Directories)),
+	)
+	for _, d := range dirs.Directories {
+		ch <- prometheus.MustNewConstMetric(
+			plotDirectoryInfoDesc,
+			prometheus.GaugeValue,
+			1,
+			d,
+		)
+		if !*localDisk {
+			continue
+		}
+		free, err := diskFree(d)
+		if err != nil {
+			log.Printf("error checking free space of %s: %v", d, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			plotDirectoryFreeDesc,
+			prometheus.GaugeValue,
+			float64(free),
+			d,
+		)
+	}
+	return dirs.Directories
+}
+
+var plotsPerDirectoryDesc = prometheus.NewDesc(
+	"chia_plots_per_directory",
+	"Number of plots the harvester is farming, per plot directory.",
+	[]string{"directory"}, nil,
+)
+
+// collectPlotsPerDirectory counts the plots under each plot directory by path
+// prefix, the longest matching directory winning. Plots outside all of them
+// are counted under directory "other".
+func collectPlotsPerDirectory(ch chan<- prometheus.Metric, plots []PlotData, dirs []string) {
+	counts := make(map[string]int, len(dirs)+1)
+	for _, d := range dirs {
+		counts[d] = 0
+	}
+	for _, p := range plots {
+		match := ""
+		for _, d := range dirs {
+			// The harvester may run on another OS than the exporter
+			dir := strings.TrimRight(d, `/\`)
+			if len(p.Filename) > len(dir) && strings.HasPrefix(p.Filename, dir) &&
+				strings.IndexByte(`/\`, p.Filename[len(dir)]) >= 0 && len(d) > len(match) {
+				match = d
+			}
+		}
+		if match == "" {
+			match = "other"
+		}
+		counts[match]++
+	}
+	for d, n := range counts {
+		ch <- prometheus.MustNewConstMetric(
+			plotsPerDirectoryDesc,
+			prometheus.GaugeValue,
+			float64(n),
+			d,
+		)
+	}
+}
+
+// collectMempoolItems reports the age of the oldest mempool item, estimated
+// from the height it was added at. Only the first -mempool_max_items items are
+// parsed, streaming the response, to bound memory use on a huge mempool.
+func (cc *ChiaCollector) collectMempoolItems(ch chan<- prometheus.Metric, bs *BlockchainState) {
+	oldest := int64(-1)
+	var found bool
+	err := cc.queryStream(cc.full_nodeURL, "get_all_mempool_items", "", func(r io.Reader) error {
+		var err error
+		found, err = decodeMempoolItems(json.NewDecoder(r), *mempoolMaxItems, func(item MempoolItem) {
+			if oldest < 0 || item.HeightAddedToMempool < oldest {
+				oldest = item.HeightAddedToMempool
+			}
+		})
+		return err
+	})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if !found {
+		log.Print("get_all_mempool_items failed")
+		return
+	}
+	age := 0.0
+	if oldest >= 0 {
+		age = float64(int64(bs.BlockchainState.Peak.Height)-oldest) * BlockTime
+	}
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_mempool_oldest_tx_age_seconds",
+			"Estimated age of the oldest mempool item, from the height it was added at, in seconds.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		age,
+	)
+}
+
+// decodeMempoolItems streams the mempool_items object of a
+// get_all_mempool_items response from dec, calling f for at most max items.
+// It reports whether the response had mempool_items, which a failed call
+// with success false has not.
+func decodeMempoolItems(dec *json.Decoder, max int, f func(MempoolItem)) (bool, error) {
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return false, fmt.Errorf("expected object: %v", err)
+	}
+	for dec.More() {
+		k, err := dec.Token()
+		if err != nil {
+			return false, err
+		}
+		if k != "mempool_items" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return false, err
+			}
+			continue
+		}
+		if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+			return false, fmt.Errorf("expected mempool_items object: %v", err)
+		}
+		for n := 0; dec.More() && n < max; n++ {
+			if _, err := dec.Token(); err != nil {
+				return false, err
+			}
+			var item MempoolItem
+			if err := dec.Decode(&item); err != nil {
+				return false, err
+			}
+			f(item)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// collectExpectedRewards estimates the daily farming income from the share of
+// the netspace held by the local plots and the current block reward.
+func (cc *ChiaCollector) collectExpectedRewards(ch chan<- prometheus.Metric, bs *BlockchainState, plots []PlotData) {
+	if bs.BlockchainState.Space <= 0 {
+		return
+	}
+	var size float64
+	for _, p := range plots {
+		size += effectivePlotSize(p)
+	}
+	reward := cc.consensus.blockRewardAtHeight(uint32(bs.BlockchainState.Peak.Height))
+	p := winProbability(size, bs.BlockchainState.Space)
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_farmer_win_probability_per_block",
+			"Probability of winning any given block, from plot share of netspace.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		p,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_expected_daily_xch",
+			"Expected daily farming reward in XCH, from plot share of netspace and current block reward.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		p*BlocksPerDay*reward,
+	)
 }
 
-func (cc ChiaCollector) collectFarmedAmount(ch chan<- prometheus.Metric, w Wallet) {
+// collectFarmedAmount returns the farmed amounts, or nil if the query failed.
+func (cc *ChiaCollector) collectFarmedAmount(ch chan<- prometheus.Metric, w Wallet, bs *BlockchainState) *FarmedAmount {
 	var farmed FarmedAmount
 	q := fmt.Sprintf(`{"wallet_id":%d}`, w.ID)
-	if err := queryAPI(cc.client, cc.walletURL, "get_farmed_amount", q, &farmed); err != nil {
+	if err := cc.query(cc.walletURL, "get_farmed_amount", q, &farmed); err != nil {
 		log.Print(err)
-		return
+		return nil
 	}
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_wallet_farmed_amount",
-			"Farmed amount",
-			[]string{"wallet_id", "wallet_fingerprint"}, nil,
+			"Amount farmed by the node, in mojo.",
+			[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
 		),
 		prometheus.GaugeValue,
 		float64(farmed.FarmedAmount),
-		w.StringID, w.PublicKey,
+		w.StringID, w.PublicKey, w.TypeName,
 	)
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_wallet_reward_amount",
-			"Reward amount",
-			[]string{"wallet_id", "wallet_fingerprint"}, nil,
+			"Farmer rewards farmed by the node, in mojo.",
+			[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
 		),
 		prometheus.GaugeValue,
 		float64(farmed.RewardAmount),
-		w.StringID, w.PublicKey,
+		w.StringID, w.PublicKey, w.TypeName,
 	)
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_wallet_fee_amount",
-			"Fee amount amount",
-			[]string{"wallet_id", "wallet_fingerprint"}, nil,
+			"Transaction fees collected in blocks farmed by the node, in mojo.",
+			[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
 		),
 		prometheus.GaugeValue,
 		float64(farmed.FeeAmount),
-		w.StringID, w.PublicKey,
+		w.StringID, w.PublicKey, w.TypeName,
 	)
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_wallet_last_height_farmed",
-			"Last height farmed",
-			[]string{"wallet_id", "wallet_fingerprint"}, nil,
+			"Height of the last block farmed by the node.",
+			[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
 		),
 		prometheus.GaugeValue,
 		float64(farmed.LastHeightFarmed),
-		w.StringID, w.PublicKey,
+		w.StringID, w.PublicKey, w.TypeName,
 	)
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"chia_wallet_pool_reward_amount",
-			"Pool Reward amount",
-			[]string{"wallet_id", "wallet_fingerprint"}, nil,
+			"Pool rewards farmed by the node, in mojo.",
+			[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
 		),
 		prometheus.GaugeValue,
 		float64(farmed.PoolRewardAmount),
-		w.StringID, w.PublicKey,
+		w.StringID, w.PublicKey, w.TypeName,
+	)
+	if bs == nil || farmed.LastHeightFarmed == 0 {
+		return &farmed
+	}
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_wallet_blocks_since_last_farmed",
+			"Number of blocks since the last block farmed by the node.",
+			[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
+		),
+		prometheus.GaugeValue,
+		float64(int64(bs.BlockchainState.Peak.Height)-farmed.LastHeightFarmed),
+		w.StringID, w.PublicKey, w.TypeName,
+	)
+	return &farmed
+}
+
+// collectNotifications counts the notifications held by the wallet, up to
+// -max_notifications. Wallets older than the notifications feature are skipped.
+func (cc *ChiaCollector) collectNotifications(ch chan<- prometheus.Metric) {
+	var ns Notifications
+	q := fmt.Sprintf(`{"start":0,"end":%d}`, *maxNotifications)
+	if err := cc.query(cc.walletURL, "get_notifications", q, &ns); err != nil {
+		if !errors.Is(err, errNoRoute) {
+			log.Print(err)
+		}
+		return
+	}
+	if !ns.Success {
+		debugf("get_notifications not supported by wallet, skipping")
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"chia_wallet_notifications",
+			"Number of notifications held by the wallet, up to -max_notifications.",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(len(ns.Notifications)),
+	)
+}
+
+var (
+	poolWalletStateDesc = prometheus.NewDesc(
+		"chia_pool_wallet_state",
+		"State of the pool wallet, 1=self pooling, 2=leaving pool, 3=farming to pool.",
+		[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
+	)
+	poolWalletTargetStateDesc = prometheus.NewDesc(
+		"chia_pool_wallet_target_state",
+		"State the pool wallet is switching to, 0 if it is not switching, otherwise as chia_pool_wallet_state.",
+		[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
+	)
+)
+
+// collectPoolWalletStatus reports the state of a pool (plot NFT) wallet, and
+// the state it is switching to.
+func (cc *ChiaCollector) collectPoolWalletStatus(ch chan<- prometheus.Metric, w Wallet) {
+	var pws PoolWalletStatus
+	q := fmt.Sprintf(`{"wallet_id":%d}`, w.ID)
+	if err := cc.query(cc.walletURL, "pw_status", q, &pws); err != nil {
+		log.Print(err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(
+		poolWalletStateDesc,
+		prometheus.GaugeValue,
+		float64(pws.State.Current.State),
+		w.StringID, w.PublicKey, w.TypeName,
+	)
+	var target int
+	if pws.State.Target != nil {
+		target = pws.State.Target.State
+	}
+	ch <- prometheus.MustNewConstMetric(
+		poolWalletTargetStateDesc,
+		prometheus.GaugeValue,
+		float64(target),
+		w.StringID, w.PublicKey, w.TypeName,
+	)
+}
+
+var walletLastTransactionDesc = prometheus.NewDesc(
+	"chia_wallet_last_transaction_timestamp_seconds",
+	"Creation time of the most recent wallet transaction, in seconds since the epoch.",
+	[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
+)
+
+// collectLastTransaction fetches only the latest transaction of the wallet,
+// sorted by confirmation height, and reports when it was created.
+func (cc *ChiaCollector) collectLastTransaction(ch chan<- prometheus.Metric, w Wallet) {
+	var txs Transactions
+	q := fmt.Sprintf(`{"wallet_id":%d,"start":0,"end":1,"sort_key":"CONFIRMED_AT_HEIGHT","reverse":true}`, w.ID)
+	if err := cc.query(cc.walletURL, "get_transactions", q, &txs); err != nil {
+		log.Print(err)
+		return
+	}
+	if len(txs.Transactions) < 1 {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(
+		walletLastTransactionDesc,
+		prometheus.GaugeValue,
+		float64(txs.Transactions[0].CreatedAtTime),
+		w.StringID, w.PublicKey, w.TypeName,
+	)
+}
+
+var walletUsedAddressesDesc = prometheus.NewDesc(
+	"chia_wallet_used_addresses",
+	"Approximate number of distinct addresses the wallet received coins on.",
+	[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
+)
+
+// collectUsedAddresses approximates the number of addresses the wallet has
+// coins on by counting the distinct addresses of its confirmed incoming
+// transactions, up to -max_transactions. Coins spent since still count, and
+// coins received without a transaction record of the wallet do not.
+func (cc *ChiaCollector) collectUsedAddresses(ch chan<- prometheus.Metric, w Wallet) {
+	var txs Transactions
+	q := fmt.Sprintf(`{"wallet_id":%d,"start":0,"end":%d}`, w.ID, *maxTransactions)
+	if err := cc.query(cc.walletURL, "get_transactions", q, &txs); err != nil {
+		log.Print(err)
+		return
+	}
+	addresses := make(map[string]bool)
+	for _, tx := range txs.Transactions {
+		if !tx.Confirmed || tx.ToAddress == "" {
+			continue
+		}
+		switch tx.Type {
+		case TransactionTypeIncoming, TransactionTypeCoinbase, TransactionTypeFeeReward, TransactionTypeIncomingTrade:
+			addresses[tx.ToAddress] = true
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(
+		walletUsedAddressesDesc,
+		prometheus.GaugeValue,
+		float64(len(addresses)),
+		w.StringID, w.PublicKey, w.TypeName,
+	)
+}
+
+var walletPendingTransactionsDesc = prometheus.NewDesc(
+	"chia_wallet_pending_transactions",
+	"Number of unconfirmed wallet transactions.",
+	[]string{"wallet_id", "wallet_fingerprint", "wallet_type"}, nil,
+)
+
+// collectPendingTransactions counts the unconfirmed transactions of the
+// wallet, up to -max_transactions. Nodes without the confirmed filter on
+// get_transactions return all transactions, so the confirmation status is
+// checked here as well.
+func (cc *ChiaCollector) collectPendingTransactions(ch chan<- prometheus.Metric, w Wallet) {
+	var txs Transactions
+	q := fmt.Sprintf(`{"wallet_id":%d,"start":0,"end":%d,"confirmed":false}`, w.ID, *maxTransactions)
+	if err := cc.query(cc.walletURL, "get_transactions", q, &txs); err != nil {
+		log.Print(err)
+		return
+	}
+	pending := 0
+	for _, tx := range txs.Transactions {
+		if !tx.Confirmed {
+			pending++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(
+		walletPendingTransactionsDesc,
+		prometheus.GaugeValue,
+		float64(pending),
+		w.StringID, w.PublicKey, w.TypeName,
 	)
 }
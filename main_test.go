@@ -0,0 +1,831 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	netproxy "golang.org/x/net/proxy"
+)
+
+// metricValues returns the values of the metrics named name among ms, keyed by
+// their labels as sorted name=value pairs joined by commas.
+func metricValues(t *testing.T, ms []prometheus.Metric, name string) map[string]float64 {
+	t.Helper()
+	values := make(map[string]float64)
+	for _, m := range ms {
+		if !strings.Contains(m.Desc().String(), fmt.Sprintf("fqName: %q", name)) {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		var labels []string
+		for _, l := range pb.Label {
+			labels = append(labels, l.GetName()+"="+l.GetValue())
+		}
+		sort.Strings(labels)
+		var v float64
+		switch {
+		case pb.Gauge != nil:
+			v = pb.Gauge.GetValue()
+		case pb.Counter != nil:
+			v = pb.Counter.GetValue()
+		case pb.Untyped != nil:
+			v = pb.Untyped.GetValue()
+		}
+		values[strings.Join(labels, ",")] = v
+	}
+	return values
+}
+
+func TestNodeTypeString(t *testing.T) {
+	tests := []struct {
+		nt   NodeType
+		want string
+	}{
+		{NodeTypeNone, "0"},
+		{NodeTypeFullNode, "full_node"},
+		{NodeTypeHarvester, "harvester"},
+		{NodeTypeFarmer, "farmer"},
+		{NodeTypeTimelord, "timelord"},
+		{NodeTypeIntroducer, "introducer"},
+		{NodeTypeWallet, "wallet"},
+		{NodeTypeWallet + 1, "7"},
+	}
+	for _, tt := range tests {
+		if got := tt.nt.String(); got != tt.want {
+			t.Errorf("NodeType(%d).String() = %q, want %q", int(tt.nt), got, tt.want)
+		}
+	}
+	if len(nodeTypeNames) != NumNodeTypes {
+		t.Errorf("%d node type names for %d node types", len(nodeTypeNames), NumNodeTypes)
+	}
+}
+
+func TestConnectionsByNodeType(t *testing.T) {
+	// One peer of each known type, which are labelled by number and name,
+	// and two of unknown types, which are ignored.
+	var conns []string
+	for nt := NodeTypeNone; nt <= NodeTypeWallet+1; nt++ {
+		conns = append(conns, fmt.Sprintf(`{"type":%d,"peer_host":"10.0.0.%d"}`, nt, nt))
+	}
+	response := `{"connections":[` + strings.Join(conns, ",") + `],"success":true}`
+	want := map[string]float64{
+		"node_type=full_node,type=1":  1,
+		"node_type=harvester,type=2":  1,
+		"node_type=farmer,type=3":     1,
+		"node_type=timelord,type=4":   1,
+		"node_type=introducer,type=5": 1,
+		"node_type=wallet,type=6":     1,
+	}
+	if len(want) != NumNodeTypes {
+		t.Fatalf("want covers %d node types, NumNodeTypes is %d", len(want), NumNodeTypes)
+	}
+
+	node := newStubEndpoint(t, map[string]string{"get_connections": response}, 0)
+	wallet := newStubEndpoint(t, map[string]string{"get_connections": response}, 0)
+	cc := newTestCollector(node.URL, wallet.URL, "disabled", "disabled")
+	ms := collectMetrics(func(ch chan<- prometheus.Metric) {
+		cc.collectConnections(ch)
+		cc.collectWalletConnections(ch)
+	})
+	for _, name := range []string{"chia_peers_count", "chia_wallet_peers"} {
+		got := metricValues(t, ms, name)
+		if len(got) != len(want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+			continue
+		}
+		for labels, v := range want {
+			if got[labels] != v {
+				t.Errorf("%s{%s} = %v, want %v", name, labels, got[labels], v)
+			}
+		}
+	}
+	if got := metricValues(t, ms, "chia_peers_introducer")[""]; got != 1 {
+		t.Errorf("chia_peers_introducer = %v, want 1", got)
+	}
+	if got := metricValues(t, ms, "chia_peers_total")[""]; got != float64(len(conns)) {
+		t.Errorf("chia_peers_total = %v, want %d", got, len(conns))
+	}
+}
+
+func TestExpectedDailyXCH(t *testing.T) {
+	const year = 1681920
+	// A farm of a thousandth of the netspace wins 4.608 of the 4608 daily
+	// blocks, whose reward halves every three years.
+	plots := []PlotData{{FileSize: 1e14}}
+	tests := []struct {
+		height int
+		want   float64
+	}{
+		{1, 9.216},
+		{3*year - 1, 9.216},
+		{3 * year, 4.608},
+		{6*year - 1, 4.608},
+		{6 * year, 2.304},
+		{9 * year, 1.152},
+		{12 * year, 0.576},
+	}
+	cc := newTestCollector("disabled", "disabled", "disabled", "disabled")
+	for _, tt := range tests {
+		var bs BlockchainState
+		bs.BlockchainState.Space = 1e17
+		bs.BlockchainState.Peak.Height = tt.height
+		ms := collectMetrics(func(ch chan<- prometheus.Metric) {
+			cc.collectExpectedRewards(ch, &bs, plots)
+		})
+		got := metricValues(t, ms, "chia_expected_daily_xch")[""]
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("height %d: chia_expected_daily_xch = %v, want %v", tt.height, got, tt.want)
+		}
+	}
+	// Without a netspace estimate nothing is reported
+	var bs BlockchainState
+	if ms := collectMetrics(func(ch chan<- prometheus.Metric) {
+		cc.collectExpectedRewards(ch, &bs, plots)
+	}); len(ms) != 0 {
+		t.Errorf("got %d metrics without netspace, want none", len(ms))
+	}
+}
+
+func TestEffectivePlotSize(t *testing.T) {
+	const size = 80e9
+	tests := []struct {
+		level int
+		want  float64
+	}{
+		{0, size},
+		{1, size * 101.4 / 87.5},
+		{7, size * 101.4 / 78.0},
+		{9, size * 101.4 / 74.8},
+		// Levels outside the table count at their file size
+		{-1, size},
+		{10, size},
+		{33, size},
+	}
+	var plots []string
+	var raw, effective float64
+	for i, tt := range tests {
+		if got := effectivePlotSize(PlotData{CompressionLevel: tt.level, FileSize: size}); math.Abs(got-tt.want) > 1e-3 {
+			t.Errorf("effectivePlotSize at level %d = %v, want %v", tt.level, got, tt.want)
+		}
+		plots = append(plots, fmt.Sprintf(`{"file_size":%d,"size":32,"compression_level":%d,"filename":"/plots/p%d.plot"}`, int64(size), tt.level, i))
+		raw += size
+		effective += tt.want
+	}
+
+	// A farm of plots at mixed levels, spread over two harvesters
+	response := fmt.Sprintf(`{"harvesters":[{"connection":{"node_id":"n1","host":"10.0.0.1"},"plots":[%s]},{"connection":{"node_id":"n2","host":"10.0.0.2"},"plots":[%s]}],"success":true}`,
+		strings.Join(plots[:3], ","), strings.Join(plots[3:], ","))
+	farmer := newStubEndpoint(t, map[string]string{"get_harvesters": response}, 0)
+	cc := newTestCollector("disabled", "disabled", farmer.URL, "disabled")
+	ms := collectMetrics(func(ch chan<- prometheus.Metric) {
+		cc.collectFarmerHarvesters(ch)
+	})
+	if got := metricValues(t, ms, "chia_farmer_total_plot_size_bytes")[""]; got != raw {
+		t.Errorf("chia_farmer_total_plot_size_bytes = %v, want %v", got, raw)
+	}
+	if got := metricValues(t, ms, "chia_farmer_effective_plot_size_bytes")[""]; math.Abs(got-effective) > 1e-3 {
+		t.Errorf("chia_farmer_effective_plot_size_bytes = %v, want %v", got, effective)
+	}
+}
+
+func TestBlockRewardAtHeight(t *testing.T) {
+	const year = 1681920
+	tests := []struct {
+		height uint32
+		want   float64
+	}{
+		{0, 21000000},
+		{1, 2},
+		{3*year - 1, 2},
+		{3 * year, 1},
+		{3*year + 1, 1},
+		{6*year - 1, 1},
+		{6 * year, 0.5},
+		{6*year + 1, 0.5},
+		{9*year - 1, 0.5},
+		{9 * year, 0.25},
+		{9*year + 1, 0.25},
+		{12*year - 1, 0.25},
+		{12 * year, 0.125},
+		{12*year + 1, 0.125},
+	}
+	for name, c := range networks {
+		for _, tt := range tests {
+			if got := c.blockRewardAtHeight(tt.height); got != tt.want {
+				t.Errorf("%s: blockRewardAtHeight(%d) = %v, want %v", name, tt.height, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestPlotFilterAtHeight(t *testing.T) {
+	tests := []struct {
+		network string
+		height  uint32
+		want    int
+	}{
+		{"mainnet", 0, 9},
+		{"mainnet", 5496000 - 1, 9},
+		{"mainnet", 5496000, 8},
+		{"mainnet", 5496000 + 1, 8},
+		{"mainnet", 10542000 - 1, 8},
+		{"mainnet", 10542000, 7},
+		{"mainnet", 10542000 + 1, 7},
+		{"mainnet", 15592000 - 1, 7},
+		{"mainnet", 15592000, 6},
+		{"mainnet", 15592000 + 1, 6},
+		{"mainnet", 20643000 - 1, 6},
+		{"mainnet", 20643000, 5},
+		{"mainnet", 20643000 + 1, 5},
+		// The hard fork is active from genesis on testnet11
+		{"testnet11", 0, 8},
+		{"testnet11", 1, 8},
+		{"testnet11", 6029568 - 1, 8},
+		{"testnet11", 6029568, 7},
+		{"testnet11", 6029568 + 1, 7},
+		{"testnet11", 11075328 - 1, 7},
+		{"testnet11", 11075328, 6},
+		{"testnet11", 11075328 + 1, 6},
+		{"testnet11", 16121088 - 1, 6},
+		{"testnet11", 16121088, 5},
+		{"testnet11", 16121088 + 1, 5},
+	}
+	for _, tt := range tests {
+		if got := networks[tt.network].plotFilterAtHeight(tt.height); got != tt.want {
+			t.Errorf("%s: plotFilterAtHeight(%d) = %d, want %d", tt.network, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestSecondsToNextEpoch(t *testing.T) {
+	tests := []struct {
+		network string
+		height  uint32
+		want    float64
+	}{
+		{"mainnet", 0, 86400},
+		{"mainnet", 4608 - 1, 18.75},
+		{"mainnet", 4608, 86400},
+		{"mainnet", 4608 + 1, 86400 - 18.75},
+		{"mainnet", 2 * 4608, 86400},
+		{"testnet11", 0, 14400},
+		{"testnet11", 768 - 1, 18.75},
+		{"testnet11", 768, 14400},
+		{"testnet11", 768 + 1, 14400 - 18.75},
+	}
+	for _, tt := range tests {
+		if got := networks[tt.network].secondsToNextEpoch(tt.height); got != tt.want {
+			t.Errorf("%s: secondsToNextEpoch(%d) = %v, want %v", tt.network, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestWinProbability(t *testing.T) {
+	const (
+		tib = 1 << 40
+		eib = 1 << 60
+	)
+	tests := []struct {
+		space, netspace float64
+		want            float64
+	}{
+		// 100 TiB out of 20 EiB wins one block in about 209715, every 45 days
+		{100 * tib, 20 * eib, 100.0 / (20 * 1024 * 1024)},
+		{1 * tib, 1 * eib, 1.0 / (1024 * 1024)},
+		{0, 20 * eib, 0},
+		{eib, eib, 1},
+		// A netspace estimate below the farm's size is capped at certainty
+		{2 * eib, eib, 1},
+		{tib, 0, 0},
+		{tib, -1, 0},
+	}
+	for _, tt := range tests {
+		got := winProbability(tt.space, tt.netspace)
+		if math.Abs(got-tt.want) > 1e-15 {
+			t.Errorf("winProbability(%v, %v) = %v, want %v", tt.space, tt.netspace, got, tt.want)
+		}
+	}
+}
+
+// setFlag sets the flag variable p to v for the rest of the test.
+func setFlag(t *testing.T, p *int64, v int64) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	setFlag(t, maxResponseBytes, 1024)
+	big := `{"blockchain_state":{"padding":"` + strings.Repeat("a", 1<<20) + `"},"success":true}`
+	node := newStubEndpoint(t, map[string]string{
+		"get_blockchain_state":  big,
+		"get_all_mempool_items": `{"mempool_items":{"0xa":{"padding":"` + strings.Repeat("a", 1<<20) + `"}},"success":true}`,
+		"get_version":           `{"version":"2.1.0","success":true}`,
+	}, 0)
+	cc := newTestCollector(node.URL, "disabled", "disabled", "disabled")
+
+	var bs BlockchainState
+	err := cc.query(node.URL, "get_blockchain_state", "", &bs)
+	if err == nil || !strings.Contains(err.Error(), "larger than -max_response_bytes=1024") {
+		t.Errorf("query: got error %v, want larger than -max_response_bytes", err)
+	}
+	err = cc.queryStream(node.URL, "get_all_mempool_items", "", func(r io.Reader) error {
+		_, err := decodeMempoolItems(json.NewDecoder(r), 10, func(MempoolItem) {})
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "larger than -max_response_bytes=1024") {
+		t.Errorf("queryStream: got error %v, want larger than -max_response_bytes", err)
+	}
+	if got := cc.stageErrors["decode"]; got != 2 {
+		t.Errorf("decode errors = %v, want 2", got)
+	}
+	// Reading stops just past the limit, however much the endpoint sends
+	for _, rpc := range []string{"get_blockchain_state", "get_all_mempool_items"} {
+		if n := cc.responseBytes[rpcKey{node.URL, rpc}]; n > 1024+bufio.MaxScanTokenSize {
+			t.Errorf("%s: read %d bytes, want about 1024", rpc, n)
+		}
+	}
+	// Responses within the limit still decode
+	var v struct{ Version string }
+	if err := cc.query(node.URL, "get_version", "", &v); err != nil || v.Version != "2.1.0" {
+		t.Errorf("get_version = %q, %v, want 2.1.0", v.Version, err)
+	}
+}
+
+func TestEndpointBaseTrailingSlash(t *testing.T) {
+	node := newStubEndpoint(t, stubFullNode, 0)
+	for _, raw := range []string{node.URL, node.URL + "/", node.URL + "//"} {
+		u, err := url.ParseRequestURI(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base, err := endpointBase("full_node", u)
+		if err != nil {
+			t.Fatalf("endpointBase(%q): %v", raw, err)
+		}
+		if base != node.URL {
+			t.Errorf("endpointBase(%q) = %q, want %q", raw, base, node.URL)
+		}
+		var v struct{ Version string }
+		if err := newTestCollector(base, "disabled", "disabled", "disabled").query(base, "get_version", "", &v); err != nil {
+			t.Errorf("%s: %v", raw, err)
+		}
+	}
+	for _, path := range node.requests() {
+		if path != "/get_version" {
+			t.Errorf("requested %q, want /get_version", path)
+		}
+	}
+	u, _ := url.ParseRequestURI("http://localhost:8555")
+	if _, err := endpointBase("full_node", u); err == nil {
+		t.Error("endpointBase accepted http://")
+	}
+}
+
+func TestApplyLegacyURL(t *testing.T) {
+	oldNode, oldURL := *full_node, *legacyURL
+	t.Cleanup(func() { *full_node, *legacyURL = oldNode, oldURL })
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "https://localhost:8555"},
+		{[]string{"-url", "https://old:8555"}, "https://old:8555"},
+		{[]string{"-full_node", "https://new:8555"}, "https://new:8555"},
+		{[]string{"-url", "https://old:8555", "-full_node", "https://new:8555"}, "https://new:8555"},
+		{[]string{"-full_node", "https://new:8555", "-url", "https://old:8555"}, "https://new:8555"},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.StringVar(full_node, "full_node", "https://localhost:8555", "")
+		fs.StringVar(legacyURL, "url", "", "")
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		applyLegacyURL(fs)
+		if *full_node != tt.want {
+			t.Errorf("%v: full_node = %q, want %q", tt.args, *full_node, tt.want)
+		}
+	}
+}
+
+func TestIPv6Endpoint(t *testing.T) {
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	s := &stubEndpoint{responses: stubFullNode}
+	s.Server = httptest.NewUnstartedServer(s)
+	s.Listener.Close()
+	s.Listener = l
+	s.StartTLS()
+	defer s.Close()
+	if !strings.HasPrefix(s.URL, "https://[::1]:") {
+		t.Fatalf("stub URL %q is not IPv6", s.URL)
+	}
+	u, err := url.ParseRequestURI(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := endpointBase("full_node", u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct{ Version string }
+	if err := newTestCollector(base, "disabled", "disabled", "disabled").query(base, "get_version", "", &v); err != nil || v.Version != "2.1.0" {
+		t.Errorf("get_version over IPv6 = %q, %v, want 2.1.0", v.Version, err)
+	}
+}
+
+// socks5Server is a minimal SOCKS5 proxy without authentication, recording
+// the addresses it was asked to connect to.
+type socks5Server struct {
+	net.Listener
+	mu      sync.Mutex
+	targets []string
+}
+
+// newSocks5Server starts a SOCKS5 proxy, closed when the test ends.
+func newSocks5Server(t *testing.T) *socks5Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socks5Server{Listener: l}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+// connected returns the addresses the proxy was asked to connect to so far.
+func (s *socks5Server) connected() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+func (s *socks5Server) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	// Greeting: version, number of methods, methods
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil || head[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(r, make([]byte, head[1])); err != nil {
+		return
+	}
+	c.Write([]byte{5, 0})
+	// Request: version, command, reserved, address type, address, port
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil || req[1] != 1 {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n, _ := r.ReadByte()
+		name := make([]byte, n)
+		io.ReadFull(r, name)
+		host = string(name)
+	case 4:
+		ip := make([]byte, 16)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+	up, err := net.Dial("tcp", target)
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer up.Close()
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(up, r)
+	io.Copy(c, up)
+}
+
+func TestSocks5(t *testing.T) {
+	oldDialers, oldDefault, oldSocks := socksDialers, defaultSocks, *socks5
+	t.Cleanup(func() { socksDialers, defaultSocks, *socks5 = oldDialers, oldDefault, oldSocks })
+	socksDialers = map[string]netproxy.ContextDialer{}
+
+	proxied := newStubEndpoint(t, stubFullNode, 0)
+	direct := newStubEndpoint(t, stubWallet, 0)
+	socks := newSocks5Server(t)
+	*socks5 = ""
+	if err := setupSocks(map[string]string{
+		proxied.URL: socks.Addr().String(),
+		direct.URL:  "direct",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cc := newTestCollector(proxied.URL, direct.URL, "disabled", "disabled")
+	cc.client.Transport.(*http.Transport).DialContext = dialContext
+	var v struct{ Version string }
+	for _, base := range []string{proxied.URL, direct.URL} {
+		if err := cc.query(base, "get_version", "", &v); err != nil {
+			t.Fatalf("%s: %v", base, err)
+		}
+	}
+	want := strings.TrimPrefix(proxied.URL, "https://")
+	if got := socks.connected(); len(got) != 1 || got[0] != want {
+		t.Errorf("SOCKS5 proxy connected to %v, want only %s", got, want)
+	}
+}
+
+// testRegistry returns a registry with a collector for a stub farm, as main
+// registers it.
+func testRegistry(t *testing.T) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newStubFarm(t, 0).collector())
+	return reg
+}
+
+func TestMetricsFormats(t *testing.T) {
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{"", "text/plain; version=0.0.4"},
+		{"text/plain;version=0.0.4;q=1,*/*;q=0.1", "text/plain; version=0.0.4"},
+		{"application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", "application/openmetrics-text; version=0.0.1"},
+	}
+	h := metricsHandler(testRegistry(t))
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.contentType) {
+			t.Errorf("Accept %q: Content-Type %q, want %s", tt.accept, got, tt.contentType)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "chia_blockchain_difficulty 112") {
+			t.Errorf("Accept %q: no chia_blockchain_difficulty in\n%s", tt.accept, body)
+		}
+		openMetrics := strings.HasPrefix(tt.contentType, "application/openmetrics-text")
+		if got := strings.HasSuffix(body, "# EOF\n"); got != openMetrics {
+			t.Errorf("Accept %q: ends in # EOF = %v, want %v", tt.accept, got, openMetrics)
+		}
+		if !openMetrics {
+			if _, err := new(expfmt.TextParser).TextToMetricFamilies(strings.NewReader(body)); err != nil {
+				t.Errorf("Accept %q: %v", tt.accept, err)
+			}
+		}
+	}
+}
+
+func TestMetricsGzip(t *testing.T) {
+	h := metricsHandler(testRegistry(t))
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := new(expfmt.TextParser).TextToMetricFamilies(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mfs["chia_blockchain_difficulty"]; !ok {
+		t.Error("no chia_blockchain_difficulty in the gunzipped response")
+	}
+}
+
+func TestPoolPointsReset(t *testing.T) {
+	cc := newTestCollector("disabled", "disabled", "disabled", "disabled")
+	const launcher, pool = "0xabc", "https://pool"
+	tests := []struct {
+		points int64
+		earned float64
+	}{
+		{10, 0}, // the first scrape only sets the baseline
+		{30, 20},
+		{45, 35},
+		{5, 40}, // payout: the points were all earned since the reset
+		{5, 40},
+		{12, 47},
+		{0, 47}, // payout with no points since
+		{3, 50},
+	}
+	for i, tt := range tests {
+		ms := collectMetrics(func(ch chan<- prometheus.Metric) {
+			cc.collectPoolPoints(ch, launcher, pool, tt.points)
+		})
+		got := metricValues(t, ms, "chia_pool_points_earned_total")["launcher_id=0xabc,pool_url=https://pool"]
+		if got != tt.earned {
+			t.Errorf("scrape %d with %d points: earned %v, want %v", i, tt.points, got, tt.earned)
+		}
+	}
+}
+
+func TestScrapesTotal(t *testing.T) {
+	cc := newTestCollector("disabled", "disabled", "disabled", "disabled")
+	cc.minScrapeInterval = time.Hour
+	scrapes := func(c prometheus.Collector) float64 {
+		return metricValues(t, collectMetrics(c.Collect), "chia_exporter_scrapes_total")[""]
+	}
+	// The second and third scrapes are served from the cache
+	for want := 1.0; want <= 3; want++ {
+		if got := scrapes(cc); got != want {
+			t.Errorf("scrape %v: chia_exporter_scrapes_total = %v, want %v", want, got, want)
+		}
+	}
+	// Other gathers report the count without adding to it
+	if got := scrapes(uncountedCollector{cc}); got != 3 {
+		t.Errorf("chia_exporter_scrapes_total = %v for an uncounted gather, want 3", got)
+	}
+	if got := scrapes(cc); got != 4 {
+		t.Errorf("chia_exporter_scrapes_total = %v, want 4", got)
+	}
+}
+
+func TestNodePublicKeys(t *testing.T) {
+	tests := []struct {
+		wallets, keys string
+		want          float64
+		fingerprint   string
+	}{
+		{`[{"id":1,"type":0},{"id":2,"type":6}]`, `[103402894,201]`, 2, "103402894"},
+		{`[{"id":1,"type":0}]`, `[]`, 0, "none"},
+		{`[]`, `[103402894]`, 1, ""},
+		{`[]`, `[]`, 0, ""},
+	}
+	for _, tt := range tests {
+		wallet := newStubEndpoint(t, stubWallet, 0)
+		wallet.set("get_wallets", `{"wallets":`+tt.wallets+`,"success":true}`)
+		wallet.set("get_public_keys", `{"public_key_fingerprints":`+tt.keys+`,"success":true}`)
+		cc := newTestCollector("disabled", wallet.URL, "disabled", "disabled")
+		ms := collectMetrics(func(ch chan<- prometheus.Metric) {
+			cc.collectWallets(ch, nil)
+		})
+		if got, ok := metricValues(t, ms, "chia_node_public_keys")[""]; !ok || got != tt.want {
+			t.Errorf("wallets %s, keys %s: chia_node_public_keys = %v (reported %v), want %v", tt.wallets, tt.keys, got, ok, tt.want)
+		}
+		var calls int
+		for _, path := range wallet.requests() {
+			if path == "/get_public_keys" {
+				calls++
+			}
+		}
+		if calls != 1 {
+			t.Errorf("wallets %s: get_public_keys called %d times, want once", tt.wallets, calls)
+		}
+		for labels := range metricValues(t, ms, "chia_wallet_info") {
+			if want := "wallet_fingerprint=" + tt.fingerprint; !strings.Contains(labels, want) {
+				t.Errorf("chia_wallet_info{%s}, want %s", labels, want)
+			}
+		}
+	}
+}
+
+func TestSkippedScrapeKeepsCache(t *testing.T) {
+	node := newStubEndpoint(t, stubFullNode, 0)
+	cc := newTestCollector(node.URL, "disabled", "disabled", "disabled")
+	cc.minScrapeInterval = time.Millisecond
+	cc.scrapeTimeout = 50 * time.Millisecond
+	difficulty := func() (float64, bool) {
+		v, ok := metricValues(t, collectMetrics(cc.Collect), "chia_blockchain_difficulty")[""]
+		return v, ok
+	}
+	if v, ok := difficulty(); !ok || v != 112 {
+		t.Fatalf("chia_blockchain_difficulty = %v (reported %v), want 112", v, ok)
+	}
+	cachedAt := cc.cachedAt
+	time.Sleep(2 * cc.minScrapeInterval)
+
+	// A round still running past the deadline skips the scrape, which serves
+	// the previous metrics
+	cc.rounds <- struct{}{}
+	node.set("get_blockchain_state", strings.Replace(stubFullNode["get_blockchain_state"], `"difficulty":112`, `"difficulty":113`, 1))
+	if v, ok := difficulty(); !ok || v != 112 {
+		t.Errorf("skipped scrape: chia_blockchain_difficulty = %v (reported %v), want the cached 112", v, ok)
+	}
+	if !cc.cachedAt.Equal(cachedAt) {
+		t.Errorf("skipped scrape moved the cache time from %s to %s", cachedAt, cc.cachedAt)
+	}
+	<-cc.rounds
+	if v, _ := difficulty(); v != 113 {
+		t.Errorf("next scrape: chia_blockchain_difficulty = %v, want 113", v)
+	}
+}
+
+func TestPendingTransactions(t *testing.T) {
+	old := *maxTransactions
+	t.Cleanup(func() { *maxTransactions = old })
+	*maxTransactions = 5000
+	// More than the 1000 counted before, and one confirmed transaction from
+	// a node without the confirmed filter
+	txs := []string{`{"confirmed":true}`}
+	for i := 0; i < 1500; i++ {
+		txs = append(txs, `{"confirmed":false}`)
+	}
+	wallet := newStubEndpoint(t, map[string]string{
+		"get_transactions": `{"transactions":[` + strings.Join(txs, ",") + `],"success":true}`,
+	}, 0)
+	cc := newTestCollector("disabled", wallet.URL, "disabled", "disabled")
+	w := Wallet{ID: 1, StringID: "1", PublicKey: "103402894", TypeName: "standard"}
+	ms := collectMetrics(func(ch chan<- prometheus.Metric) {
+		cc.collectPendingTransactions(ch, w)
+	})
+	got := metricValues(t, ms, "chia_wallet_pending_transactions")["wallet_fingerprint=103402894,wallet_id=1,wallet_type=standard"]
+	if got != 1500 {
+		t.Errorf("chia_wallet_pending_transactions = %v, want 1500", got)
+	}
+	wallet.mu.Lock()
+	defer wallet.mu.Unlock()
+	if len(wallet.bodies) != 1 || !strings.Contains(wallet.bodies[0], `"end":5000`) {
+		t.Errorf("get_transactions requested with %q, want up to -max_transactions=5000", wallet.bodies)
+	}
+}
+
+func TestParseHostCerts(t *testing.T) {
+	tests := []struct {
+		flag string
+		want map[string][2]string
+	}{
+		{"", map[string][2]string{}},
+		{
+			"node1=/ssl/node1.crt:/ssl/node1.key, node2=/ssl/node2.crt:/ssl/node2.key",
+			map[string][2]string{
+				"node1": {"/ssl/node1.crt", "/ssl/node1.key"},
+				"node2": {"/ssl/node2.crt", "/ssl/node2.key"},
+			},
+		},
+		{
+			"[::1]=/ssl/node.crt:/ssl/node.key",
+			map[string][2]string{"::1": {"/ssl/node.crt", "/ssl/node.key"}},
+		},
+		{
+			`node1=C:\ssl\node1.crt:C:\ssl\node1.key,node2=D:/ssl/node2.crt:ssl\node2.key,node3=ssl\node3.crt:C:\ssl\node3.key`,
+			map[string][2]string{
+				"node1": {`C:\ssl\node1.crt`, `C:\ssl\node1.key`},
+				"node2": {`D:/ssl/node2.crt`, `ssl\node2.key`},
+				"node3": {`ssl\node3.crt`, `C:\ssl\node3.key`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseHostCerts(tt.flag)
+		if err != nil {
+			t.Errorf("parseHostCerts(%q): %v", tt.flag, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseHostCerts(%q) = %v, want %v", tt.flag, got, tt.want)
+		}
+	}
+	for _, invalid := range []string{"node1", "node1=/ssl/node1.crt", `node1=C:\ssl\node1.crt`, "=/a.crt:/a.key", "node1=/a.crt:"} {
+		if _, err := parseHostCerts(invalid); err == nil {
+			t.Errorf("parseHostCerts(%q) accepted an invalid entry", invalid)
+		}
+	}
+}
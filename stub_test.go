@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	// The collectors log every failed query, which is noise in tests that
+	// fail queries on purpose.
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// Canned responses of each service, following the examples of the RPC
+// documentation.
+var (
+	stubFullNode = map[string]string{
+		"get_connections":      `{"connections":[{"type":1,"peer_host":"1.2.3.4","local_port":8444,"peer_port":5000,"peer_server_port":8444},{"type":1,"peer_host":"1.2.3.5","local_port":50000,"peer_port":8444,"peer_server_port":8444},{"type":3,"peer_host":"127.0.0.1"}],"success":true}`,
+		"get_network_info":     `{"network_name":"mainnet","network_prefix":"xch","success":true}`,
+		"get_blockchain_state": `{"blockchain_state":{"difficulty":112,"space":3.0e19,"sub_slot_iters":147849216,"peak":{"height":2000000,"total_iters":12345,"timestamp":null},"sync":{"synced":true,"sync_mode":false,"sync_progress_height":0,"sync_tip_height":0}},"success":true}`,
+		"get_version":          `{"version":"2.1.0","success":true}`,
+	}
+	stubWallet = map[string]string{
+		"get_wallets":        `{"wallets":[{"id":1,"name":"Chia Wallet","type":0,"data":""}],"success":true}`,
+		"get_public_keys":    `{"public_key_fingerprints":[103402894],"success":true}`,
+		"get_wallet_balance": `{"wallet_balance":{"confirmed_wallet_balance":100,"max_send_amount":100,"pending_change":0,"spendable_balance":100,"unconfirmed_wallet_balance":100,"wallet_id":1},"success":true}`,
+		"get_sync_status":    `{"genesis_initialized":true,"synced":true,"syncing":false,"success":true}`,
+		"get_height_info":    `{"height":1999990,"success":true}`,
+		"get_farmed_amount":  `{"farmed_amount":2000000000000,"farmer_reward_amount":250000000000,"fee_amount":10,"last_height_farmed":1990000,"pool_reward_amount":1750000000000,"success":true}`,
+		"get_transactions":   `{"transactions":[{"created_at_time":1625140344,"confirmed":true,"confirmed_at_height":1990000,"amount":1}],"success":true}`,
+		"get_notifications":  `{"notifications":[],"success":true}`,
+		"get_connections":    `{"connections":[{"type":1,"peer_host":"1.2.3.4"}],"success":true}`,
+		"get_version":        `{"version":"2.1.0","success":true}`,
+	}
+	stubFarmer = map[string]string{
+		"get_pool_state": `{"pool_state":[{"current_difficulty":1,"current_points":12,"points_acknowledged_24h":[[1625140000,1]],"points_found_24h":[[1625140000,1]],"pool_config":{"launcher_id":"0xabc","pool_url":"https://pool"}}],"success":true}`,
+		"get_harvesters": `{"harvesters":[{"connection":{"node_id":"n1","host":"127.0.0.1","port":1},"plots":[{"file_size":108000000000,"size":32,"filename":"/plots/a/p1.plot","pool_contract_puzzle_hash":"0x1"}],"failed_to_open_filenames":[],"no_key_filenames":[]}],"success":true}`,
+		"get_version":    `{"version":"2.1.0","success":true}`,
+	}
+	stubHarvester = map[string]string{
+		"get_plots":            `{"plots":[{"file_size":108000000000,"size":32,"filename":"/plots/a/p1.plot"}],"failed_to_open_filenames":[],"not_found_filenames":[],"success":true}`,
+		"get_plot_directories": `{"directories":["/plots/a"],"success":true}`,
+		"get_version":          `{"version":"2.1.0","success":true}`,
+	}
+)
+
+// stubEndpoint is a Chia RPC endpoint answering each rpc with its canned
+// response after latency, and 404 for rpcs it has none for, as Chia services
+// do for missing routes.
+type stubEndpoint struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[string]string
+	latency   time.Duration
+	// paths and bodies of the requests received, in order
+	paths  []string
+	bodies []string
+}
+
+// newStubEndpoint starts a stub endpoint with a copy of responses, closed when
+// the test ends.
+func newStubEndpoint(tb testing.TB, responses map[string]string, latency time.Duration) *stubEndpoint {
+	s := &stubEndpoint{
+		responses: make(map[string]string, len(responses)),
+		latency:   latency,
+	}
+	for rpc, r := range responses {
+		s.responses[rpc] = r
+	}
+	s.Server = httptest.NewTLSServer(s)
+	tb.Cleanup(s.Close)
+	return s
+}
+
+func (s *stubEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	s.paths = append(s.paths, r.URL.Path)
+	s.bodies = append(s.bodies, string(body))
+	response, ok := s.responses[strings.TrimPrefix(r.URL.Path, "/")]
+	latency := s.latency
+	s.mu.Unlock()
+	time.Sleep(latency)
+	if !ok {
+		http.Error(w, "404: Not Found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, response)
+}
+
+// set replaces the response to rpc.
+func (s *stubEndpoint) set(rpc, response string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[rpc] = response
+}
+
+// requests returns the paths requested so far.
+func (s *stubEndpoint) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.paths...)
+}
+
+// stubClient is a client for the stub endpoints, which serve a self-signed
+// certificate.
+func stubClient() *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+}
+
+// stubFarm is a stub of each of the four services.
+type stubFarm struct {
+	fullNode, wallet, farmer, harvester *stubEndpoint
+}
+
+// newStubFarm starts stubs of the four services answering after latency.
+func newStubFarm(tb testing.TB, latency time.Duration) stubFarm {
+	return stubFarm{
+		fullNode:  newStubEndpoint(tb, stubFullNode, latency),
+		wallet:    newStubEndpoint(tb, stubWallet, latency),
+		farmer:    newStubEndpoint(tb, stubFarmer, latency),
+		harvester: newStubEndpoint(tb, stubHarvester, latency),
+	}
+}
+
+// newTestCollector returns a collector for the given base URLs, set up as main
+// does with the default flags.
+func newTestCollector(fullNode, wallet, farmer, harvester string) *ChiaCollector {
+	return &ChiaCollector{
+		client:       stubClient(),
+		full_nodeURL: fullNode,
+		walletURL:    wallet,
+		farmerURL:    farmer,
+		harvesterURL: harvester,
+
+		harvesterStaleAfter: 5 * time.Minute,
+		consensus:           networks["mainnet"],
+		rounds:              make(chan struct{}, 1),
+
+		poolDifficulty:        make(map[string]int64),
+		poolDifficultyChanges: make(map[string]float64),
+		poolPoints:            make(map[string]poolPoints),
+		poolPointsEarned:      make(map[string]float64),
+		endpointOK:            make(map[string]bool),
+		consecutiveFailures:   make(map[string]int),
+		responseBytes:         make(map[rpcKey]int64),
+		missingRoutes:         make(map[rpcKey]bool),
+		stageErrors:           make(map[string]float64),
+	}
+}
+
+// collector returns a collector for the stubs.
+func (f stubFarm) collector() *ChiaCollector {
+	return newTestCollector(f.fullNode.URL, f.wallet.URL, f.farmer.URL, f.harvester.URL)
+}
+
+// collectMetrics runs f with a channel and returns the metrics it sent.
+func collectMetrics(f func(chan<- prometheus.Metric)) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		f(ch)
+		close(ch)
+	}()
+	var ms []prometheus.Metric
+	for m := range ch {
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+// BenchmarkScrape compares a serial scrape of the four endpoints with
+// scraping them concurrently. Collection is serial, so the concurrent case
+// scrapes a collector per endpoint in parallel: the time a scrape could take
+// if the endpoints were collected concurrently, without the metrics derived
+// across endpoints.
+func BenchmarkScrape(b *testing.B) {
+	for _, latency := range []time.Duration{0, time.Millisecond, 10 * time.Millisecond} {
+		f := newStubFarm(b, latency)
+		b.Run(fmt.Sprintf("serial/%s", latency), func(b *testing.B) {
+			cc := f.collector()
+			for i := 0; i < b.N; i++ {
+				collectMetrics(func(ch chan<- prometheus.Metric) {
+					cc.scrape(ch, true)
+				})
+			}
+		})
+		b.Run(fmt.Sprintf("concurrent/%s", latency), func(b *testing.B) {
+			ccs := []*ChiaCollector{
+				newTestCollector(f.fullNode.URL, "disabled", "disabled", "disabled"),
+				newTestCollector("disabled", f.wallet.URL, "disabled", "disabled"),
+				newTestCollector("disabled", "disabled", f.farmer.URL, "disabled"),
+				newTestCollector("disabled", "disabled", "disabled", f.harvester.URL),
+			}
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for _, cc := range ccs {
+					wg.Add(1)
+					go func(cc *ChiaCollector) {
+						defer wg.Done()
+						collectMetrics(func(ch chan<- prometheus.Metric) {
+							cc.scrape(ch, true)
+						})
+					}(cc)
+				}
+				wg.Wait()
+			}
+		})
+	}
+}